@@ -0,0 +1,305 @@
+// Copyright CERN.
+//
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/cernops/cvmfs-csi/internal"
+	"github.com/cernops/cvmfs-csi/pkg/cvmfs"
+)
+
+// adminCommands inspect what the node plugin has set up, run in its container
+// with the same directories, e.g. csi-cvmfsplugin mounts --output=json
+var adminCommands = map[string]struct {
+	usage string
+	run   func(a *cvmfs.Admin, args adminArgs, out *output) error
+}{
+	"status":  {"", adminStatus},
+	"volumes": {"", adminVolumes},
+	"mounts":  {"", adminMounts},
+	"repos":   {"", adminRepos},
+	"caches":  {"", adminCaches},
+	"probe":   {"<repository>", adminProbe},
+	"refresh": {"<repository>", adminRefresh},
+	"cleanup": {"", adminCleanup},
+	"ready":   {"", adminReady},
+}
+
+// adminCommand runs an admin subcommand
+func adminCommand(name string, argv []string) {
+	cmd := adminCommands[name]
+
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	c := cvmfs.DriverConfig{}
+	fs.StringVar(&c.DriverName, "drivername", "cvmfs.csi.cern.ch", "name of the driver")
+	fs.StringVar(&c.CacheFolder, "cache-folder", "/var/cache/cvmfs", "cache location of the node plugin")
+	fs.StringVar(&c.StateDir, "state-dir", "/csi-data-dir", "state directory of the node plugin")
+	fs.StringVar(&c.KubeletDir, "kubelet-dir", "/var/lib/kubelet", "root directory of the kubelet")
+	fs.StringVar(&c.MountHolder, "mount-holder", "", "unix socket of the mount holder, to unmount through when the node plugin runs with --fuse-fd-passing")
+	format := fs.String("output", "text", "output format (text|json)")
+	logLevel := fs.String("log.level", "warn", "log level")
+	args := adminArgs{}
+	if name == "cleanup" {
+		fs.BoolVar(&args.dryRun, "dry-run", false, "only show what would be cleaned up")
+	}
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s %s [flags] %s\n", os.Args[0], name, cmd.usage)
+		fs.PrintDefaults()
+	}
+	fs.Parse(argv)
+	internal.InitLogging(*logLevel, "plain")
+
+	if *format != "text" && *format != "json" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	args.positional = fs.Args()
+	if (cmd.usage == "" && len(args.positional) != 0) || (cmd.usage != "" && len(args.positional) != 1) {
+		fs.Usage()
+		os.Exit(2)
+	}
+	out := &output{w: os.Stdout, json: *format == "json"}
+
+	a, err := cvmfs.NewAdmin(c)
+	if err == nil {
+		err = cmd.run(a, args, out)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+		os.Exit(1)
+	}
+}
+
+// adminArgs are the arguments and subcommand specific flags of an admin subcommand
+type adminArgs struct {
+	positional []string
+	dryRun     bool
+}
+
+// output prints reports as JSON, or as a table for humans
+type output struct {
+	w    io.Writer
+	json bool
+}
+
+// print writes v as JSON, or calls table to write it for humans
+func (o *output) print(v interface{}, table func(w io.Writer)) error {
+	if o.json {
+		enc := json.NewEncoder(o.w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+	tw := tabwriter.NewWriter(o.w, 0, 8, 2, ' ', 0)
+	table(tw)
+	return tw.Flush()
+}
+
+// list formats a list for a table cell
+func list(l []string) string {
+	if len(l) == 0 {
+		return "-"
+	}
+	return strings.Join(l, ",")
+}
+
+func health(healthy bool) string {
+	if healthy {
+		return "healthy"
+	}
+	return "broken"
+}
+
+func adminStatus(a *cvmfs.Admin, _ adminArgs, out *output) error {
+	st, err := a.Status()
+	if err != nil {
+		return err
+	}
+	return out.print(st, func(w io.Writer) {
+		fmt.Fprintf(w, "driver:\t%s\n", st.DriverName)
+		fmt.Fprintf(w, "staged volumes:\t%d\n", st.StagedVolumes)
+		fmt.Fprintf(w, "published targets:\t%d\n", st.PublishedTargets)
+		fmt.Fprintf(w, "mounted repositories:\t%d\n", st.Repositories)
+		fmt.Fprintf(w, "isolated clients:\t%d\n", st.IsolatedClients)
+		fmt.Fprintf(w, "broken mounts:\t%d\n", st.BrokenMounts)
+		fmt.Fprintf(w, "untracked mounts:\t%d\n", st.UntrackedMounts)
+		fmt.Fprintf(w, "volumes without staging path:\t%d\n", st.VolumesWithoutDir)
+		fmt.Fprintf(w, "cache size:\t%d MB\n", st.CacheBytes>>20)
+	})
+}
+
+func adminVolumes(a *cvmfs.Admin, _ adminArgs, out *output) error {
+	volumes, err := a.Volumes()
+	if err != nil {
+		return err
+	}
+	return out.print(volumes, func(w io.Writer) {
+		fmt.Fprintln(w, "VOLUME\tREPOSITORY\tISOLATED\tSTAGING PATH\tTARGETS")
+		for _, v := range volumes {
+			fmt.Fprintf(w, "%s\t%s\t%t\t%s\t%s\n", v.VolumeID, v.Repository, v.Isolated, v.StagingTargetPath, list(v.Targets))
+		}
+	})
+}
+
+func adminMounts(a *cvmfs.Admin, _ adminArgs, out *output) error {
+	mounts, err := a.Mounts()
+	if err != nil {
+		return err
+	}
+	return out.print(mounts, func(w io.Writer) {
+		fmt.Fprintln(w, "MOUNT POINT\tKIND\tREPOSITORY\tSTATE\tVOLUMES")
+		for _, m := range mounts {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", m.MountPoint, m.Kind, m.Repository, health(m.Healthy), list(m.VolumeIDs))
+		}
+	})
+}
+
+func adminRepos(a *cvmfs.Admin, _ adminArgs, out *output) error {
+	repos, err := a.Repositories()
+	if err != nil {
+		return err
+	}
+	return out.print(repos, func(w io.Writer) {
+		fmt.Fprintln(w, "REPOSITORY\tSTATE\tREVISION\tCACHE (MB)\tPROXY\tVOLUMES")
+		for _, r := range repos {
+			fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%s\t%s\n", r.Repository, health(r.Healthy), r.Revision, r.CacheUsed>>20, r.Proxy, list(r.VolumeIDs))
+		}
+	})
+}
+
+func adminCaches(a *cvmfs.Admin, _ adminArgs, out *output) error {
+	caches, err := a.Caches()
+	if err != nil {
+		return err
+	}
+	return out.print(caches, func(w io.Writer) {
+		fmt.Fprintln(w, "CACHE\tSIZE (MB)\tREPOSITORIES")
+		for _, c := range caches {
+			fmt.Fprintf(w, "%s\t%d\t%s\n", c.Path, c.Bytes>>20, list(c.Repositories))
+		}
+	})
+}
+
+func adminProbe(a *cvmfs.Admin, args adminArgs, out *output) error {
+	r, err := a.Probe(cvmfs.Repository(args.positional[0]))
+	if err != nil {
+		return err
+	}
+	if err := out.print(r, func(w io.Writer) {
+		fmt.Fprintf(w, "repository:\t%s\n", r.Repository)
+		fmt.Fprintf(w, "mounted:\t%t\n", r.Mounted)
+		if r.Mounted {
+			fmt.Fprintf(w, "state:\t%s\n", health(r.Healthy))
+			fmt.Fprintf(w, "revision:\t%d\n", r.Revision)
+			fmt.Fprintf(w, "root hash:\t%s\n", r.RootHash)
+			fmt.Fprintf(w, "host:\t%s\n", r.Host)
+			fmt.Fprintf(w, "proxy:\t%s\n", r.Proxy)
+			fmt.Fprintf(w, "cache:\t%s\n", r.CacheBase)
+		}
+		if r.Error != "" {
+			fmt.Fprintf(w, "error:\t%s\n", r.Error)
+		}
+	}); err != nil {
+		return err
+	}
+	if !r.Healthy {
+		os.Exit(1)
+	}
+	return nil
+}
+
+func adminRefresh(a *cvmfs.Admin, args adminArgs, out *output) error {
+	reports, err := a.Refresh(cvmfs.Repository(args.positional[0]))
+	if err != nil {
+		return err
+	}
+	if err := out.print(reports, func(w io.Writer) {
+		fmt.Fprintln(w, "MOUNT POINT\tVOLUMES\tFROM\tTO\tRESULT")
+		for _, r := range reports {
+			result := "done"
+			if r.Error != "" {
+				result = r.Error
+			}
+			fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%s\n", r.MountPoint, list(r.VolumeIDs), r.From, r.To, result)
+		}
+	}); err != nil {
+		return err
+	}
+	for _, r := range reports {
+		if r.Error != "" {
+			os.Exit(1)
+		}
+	}
+	return nil
+}
+
+func adminCleanup(a *cvmfs.Admin, args adminArgs, out *output) error {
+	actions, err := a.Cleanup(args.dryRun)
+	if err != nil {
+		return err
+	}
+	if err := out.print(actions, func(w io.Writer) {
+		fmt.Fprintln(w, "ACTION\tPATH\tVOLUME\tREASON\tRESULT")
+		for _, c := range actions {
+			result := "done"
+			switch {
+			case c.Error != "":
+				result = c.Error
+			case args.dryRun:
+				result = "dry run"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", c.Action, c.Path, c.VolumeID, c.Reason, result)
+		}
+	}); err != nil {
+		return err
+	}
+	for _, c := range actions {
+		if c.Error != "" {
+			os.Exit(1)
+		}
+	}
+	return nil
+}
+
+func adminReady(a *cvmfs.Admin, _ adminArgs, out *output) error {
+	err := a.Ready()
+	r := struct {
+		Ready    bool   `json:"ready"`
+		NotReady string `json:"notReady,omitempty"`
+	}{Ready: err == nil}
+	if err != nil {
+		r.NotReady = err.Error()
+	}
+	if err := out.print(r, func(w io.Writer) {
+		fmt.Fprintf(w, "ready:\t%t\n", r.Ready)
+		if r.NotReady != "" {
+			fmt.Fprintf(w, "reason:\t%s\n", r.NotReady)
+		}
+	}); err != nil {
+		return err
+	}
+	if !r.Ready {
+		os.Exit(1)
+	}
+	return nil
+}
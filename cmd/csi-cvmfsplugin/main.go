@@ -18,6 +18,7 @@ package main
 
 import (
 	"flag"
+	"os"
 
 	"github.com/cernops/cvmfs-csi/internal"
 	"github.com/cernops/cvmfs-csi/pkg/cvmfs"
@@ -30,10 +31,40 @@ var (
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		if os.Args[1] == "mount-holder" {
+			mountHolder(os.Args[2:])
+			return
+		}
+		if _, ok := adminCommands[os.Args[1]]; ok {
+			adminCommand(os.Args[1], os.Args[2:])
+			return
+		}
+	}
+
 	flag.StringVar(&config.Endpoint, "csi-address", "unix:///csi/csi.sock", "CSI socket address to share with helper sidecar containers (e.g. csi-attacher)")
 	flag.StringVar(&config.DriverName, "drivername", "cvmfs.csi.cern.ch", "name of the driver. To be used as 'provisioner' for K8S StorageClasses")
 	flag.StringVar(&config.Proxy, "cvmfs-proxy", "http://ca-proxy.cern.ch:3128", "proxy to use for CVMFS mounts")
 	flag.StringVar(&config.CacheFolder, "cache-folder", "/var/cache/cvmfs", "cache location to use for CVMFS mounts")
+	flag.Var(&config.CachePolicy, "cache-policy", "which repositories share a cache directory (shared|per-repository)")
+	flag.Uint64Var(&config.CacheQuota, "cache-quota", 0, "default cache quota (CVMFS_QUOTA_LIMIT) of every cache directory in MB, 0 for the client default")
+	flag.Var(&config.CacheType, "cache-type", "cache manager of the clients (posix|alien|ram|tiered)")
+	flag.StringVar(&config.AlienCacheDir, "alien-cache-dir", "", "alien cache directory, for cache type alien or as lower tier of cache type tiered")
+	flag.BoolVar(&config.AlienCacheReadOnly, "alien-cache-readonly", false, "do not write to the lower tier of a tiered cache, e.g. a pre-populated alien cache")
+	flag.Uint64Var(&config.RAMCacheSize, "ram-cache-size", 0, "size of the RAM cache in MB, for cache type ram or as upper tier of cache type tiered")
+	flag.StringVar(&config.ProfilesFile, "profiles-file", "", "JSON file with the site profiles volumes can select with the profile parameter")
+	flag.StringVar(&config.PublicKeysDir, "public-keys-dir", "", "directory with additional repository keys, holding a directory of .pub files per domain")
+	flag.StringVar(&config.PreloadDir, "preload-dir", "", "directory with preloaded caches for offline volumes, empty to disable them")
+	flag.StringVar(&config.MountHolder, "mount-holder", "", "unix socket of the mount holder running the cvmfs clients, empty to run them in the plugin")
+	flag.BoolVar(&config.FuseFdPassing, "fuse-fd-passing", false, "have the mount holder mount fuse filesystems and run the cvmfs clients unprivileged as --client-user, requires --mount-holder")
+	flag.StringVar(&config.ClientUser, "client-user", "cvmfs", "user name or uid:gid the cvmfs clients run as with --fuse-fd-passing")
+	flag.BoolVar(&config.Automount, "automount", false, "run autofs on /cvmfs, allowing volumes that expose all repositories")
+	flag.StringVar(&config.Site, "site", "", "site this node belongs to, reported as topology segment")
+	flag.StringVar(&config.ProxyGroup, "proxy-group", "", "proxy group this node belongs to, reported as topology segment")
+	flag.StringVar(&config.StateDir, "state-dir", "/csi-data-dir", "persistent directory to keep track of staged and published volumes")
+	flag.StringVar(&config.KubeletDir, "kubelet-dir", "/var/lib/kubelet", "root directory of the kubelet, used to find stale mounts")
+	flag.BoolVar(&config.KubeEvents, "kube-events", false, "record Kubernetes events on pods and volumes when mounts fail")
+	flag.StringVar(&config.DebugAddress, "debug-address", "", "address to serve the driver state and pprof on over HTTP, e.g. 127.0.0.1:9808, empty to disable")
 	flag.StringVar(&config.NodeID, "nodeid", "", "name of the node this runs on (recommended to use spec.nodeName in your statefulset/deployment)")
 	flag.Parse()
 	internal.InitLogging(*logLevel, *logMode)
@@ -49,3 +80,23 @@ func main() {
 	driver.Run()
 	log.Warn().Msg("finished")
 }
+
+// mountHolder runs the mount holder, which keeps the cvmfs clients of the plugin
+// running in a container of its own
+func mountHolder(args []string) {
+	fs := flag.NewFlagSet("mount-holder", flag.ExitOnError)
+	c := cvmfs.MountHolderConfig{}
+	fs.StringVar(&c.Socket, "socket", "/csi-data-dir/mount-holder.sock", "unix socket to serve the node plugin on")
+	fs.StringVar(&c.StateDir, "state-dir", "/csi-data-dir", "state directory of the node plugin, clients of isolated volumes only run below it")
+	fs.StringVar(&c.KubeletDir, "kubelet-dir", "/var/lib/kubelet", "root directory of the kubelet, volumes are only bind-mounted below it")
+	logLevel := fs.String("log.level", "info", "log level")
+	logMode := fs.String("log.mode", "plain", "log mode (plain|json)")
+	fs.Parse(args)
+	internal.InitLogging(*logLevel, *logMode)
+
+	log := internal.GetLogger("")
+	log.Info().Msg("starting mount holder")
+	if err := cvmfs.RunMountHolder(c); err != nil {
+		log.Fatal().Err(err).Msg("mount holder failed")
+	}
+}
@@ -4,7 +4,7 @@ go 1.16
 
 require (
 	github.com/container-storage-interface/spec v1.4.0
-	github.com/golang/protobuf v1.5.2 // indirect
+	github.com/golang/protobuf v1.5.2
 	github.com/google/uuid v1.1.2
 	github.com/kubernetes-csi/csi-lib-utils v0.9.1
 	github.com/rs/zerolog v1.22.0
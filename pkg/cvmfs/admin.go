@@ -0,0 +1,597 @@
+// Copyright CERN.
+//
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cvmfs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/cernops/cvmfs-csi/internal"
+)
+
+// probeTimeout is how long probing a repository may take, mounting it on first access included
+const probeTimeout = 30 * time.Second
+
+// Admin inspects what the node plugin has set up on a node, for the admin subcommands.
+// It reads the state directory and the mount table, and only changes anything in Cleanup
+// and Refresh.
+type Admin struct {
+	d *Driver
+}
+
+// NewAdmin returns an Admin for the node plugin running with configuration c
+func NewAdmin(c DriverConfig) (*Admin, error) {
+	if c.StateDir == "" {
+		return nil, errors.New("State directory missing")
+	}
+	// unlike the driver, never create the state directory
+	if _, err := os.Stat(c.StateDir); err != nil {
+		return nil, fmt.Errorf("cannot access state directory: %w", err)
+	}
+	if err := validateCacheConfig(&c); err != nil {
+		return nil, fmt.Errorf("Invalid cache configuration: %w", err)
+	}
+	if c.MountHolder != "" {
+		// the node plugin container is not privileged, the mount holder unmounts for it
+		mountHelper = newMountHolderClient(c.MountHolder)
+	}
+	return &Admin{d: &Driver{config: c, state: &stateStore{dir: c.StateDir}}}, nil
+}
+
+// VolumeReport is a staged volume
+type VolumeReport struct {
+	VolumeID          string   `json:"volumeID"`
+	Repository        string   `json:"repository"`
+	StagingTargetPath string   `json:"stagingTargetPath"`
+	Targets           []string `json:"targets,omitempty"`
+	Isolated          bool     `json:"isolated,omitempty"`
+	Protected         bool     `json:"protected,omitempty"`
+	Identity          string   `json:"identity,omitempty"`
+}
+
+// MountReport is a cvmfs mount on the node
+type MountReport struct {
+	MountPoint string `json:"mountPoint"`
+	// Kind is repository or isolated for the mounts of clients, staging or target for
+	// the bind mounts of volumes, and untracked for bind mounts nothing knows about
+	Kind       string `json:"kind"`
+	Repository string `json:"repository,omitempty"`
+	Healthy    bool   `json:"healthy"`
+	// VolumeIDs are the volumes depending on the mount
+	VolumeIDs []string `json:"volumeIDs,omitempty"`
+	// Dependents are the staging and target paths showing a client mount
+	Dependents []string `json:"dependents,omitempty"`
+}
+
+// Kinds of MountReport
+const (
+	mountKindRepository = "repository"
+	mountKindIsolated   = "isolated"
+	mountKindStaging    = "staging"
+	mountKindTarget     = "target"
+	mountKindUntracked  = "untracked"
+)
+
+// RepositoryReport is a repository mounted on /cvmfs, or one that was probed
+type RepositoryReport struct {
+	Repository string   `json:"repository"`
+	MountPoint string   `json:"mountPoint"`
+	Mounted    bool     `json:"mounted"`
+	Healthy    bool     `json:"healthy"`
+	CacheBase  string   `json:"cacheBase,omitempty"`
+	VolumeIDs  []string `json:"volumeIDs,omitempty"`
+	// the fields below are asked from the client, Error tells why they are missing
+	Revision    uint64 `json:"revision,omitempty"`
+	RootHash    string `json:"rootHash,omitempty"`
+	Proxy       string `json:"proxy,omitempty"`
+	Host        string `json:"host,omitempty"`
+	CacheUsed   uint64 `json:"cacheUsed,omitempty"`
+	CachePinned uint64 `json:"cachePinned,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// RefreshReport is a client that was asked to switch to the latest revision of its repository
+type RefreshReport struct {
+	MountPoint string   `json:"mountPoint"`
+	VolumeIDs  []string `json:"volumeIDs,omitempty"`
+	From       uint64   `json:"from,omitempty"`
+	To         uint64   `json:"to,omitempty"`
+	Error      string   `json:"error,omitempty"`
+}
+
+// CacheReport is a cache directory and its size on disk
+type CacheReport struct {
+	Path  string `json:"path"`
+	Bytes int64  `json:"bytes"`
+	// Repositories are the mounted repositories using the cache
+	Repositories []string `json:"repositories,omitempty"`
+}
+
+// StatusReport summarizes the node
+type StatusReport struct {
+	DriverName        string `json:"driverName"`
+	StagedVolumes     int    `json:"stagedVolumes"`
+	PublishedTargets  int    `json:"publishedTargets"`
+	Repositories      int    `json:"repositories"`
+	IsolatedClients   int    `json:"isolatedClients"`
+	BrokenMounts      int    `json:"brokenMounts"`
+	UntrackedMounts   int    `json:"untrackedMounts"`
+	VolumesWithoutDir int    `json:"volumesWithoutStagingPath"`
+	CacheBytes        int64  `json:"cacheBytes"`
+}
+
+// CleanupAction is something Cleanup did, or would do on a dry run
+type CleanupAction struct {
+	// Action is unmount for untracked mounts, or forget for volumes whose staging path is gone
+	Action   string `json:"action"`
+	Path     string `json:"path"`
+	VolumeID string `json:"volumeID,omitempty"`
+	Reason   string `json:"reason"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Volumes returns the staged volumes
+func (a *Admin) Volumes() ([]VolumeReport, error) {
+	volumes, err := a.d.state.List()
+	if err != nil {
+		return nil, err
+	}
+
+	reports := []VolumeReport{}
+	for _, v := range volumes {
+		r := VolumeReport{
+			VolumeID:          v.VolumeID,
+			Repository:        string(v.Options.Repository),
+			StagingTargetPath: v.StagingTargetPath,
+			Isolated:          v.Isolated,
+			Protected:         v.Options.Protected,
+			Identity:          v.Identity,
+		}
+		for t := range v.Targets {
+			r.Targets = append(r.Targets, t)
+		}
+		sort.Strings(r.Targets)
+		reports = append(reports, r)
+	}
+	return reports, nil
+}
+
+// Mounts returns the cvmfs mounts on the node, and what depends on them
+func (a *Admin) Mounts() ([]MountReport, error) {
+	volumes, err := a.d.state.List()
+	if err != nil {
+		return nil, err
+	}
+	mounts, err := listMounts()
+	if err != nil {
+		return nil, err
+	}
+	owners := a.mountOwners(volumes)
+
+	reports := []MountReport{}
+	seen := map[string]bool{}
+	for _, m := range mounts {
+		if !m.isCVMFS() || seen[m.MountPoint] {
+			continue
+		}
+		seen[m.MountPoint] = true
+
+		r := MountReport{MountPoint: m.MountPoint, Kind: mountKindUntracked}
+		if o, ok := owners.of(m.MountPoint); ok {
+			r.Kind = o.kind
+			r.Repository = string(o.v.Options.Repository)
+			r.VolumeIDs = []string{o.v.VolumeID}
+			if o.v.Options.Automount {
+				// below the staging or target path of an automount volume
+				r.Repository = filepath.Base(m.MountPoint)
+			}
+		} else if filepath.Dir(m.MountPoint) == AutomountRoot {
+			r.Kind = mountKindRepository
+			r.Repository = filepath.Base(m.MountPoint)
+		}
+
+		if info, err := inspectMountIn(mounts, m.MountPoint); err == nil && info != nil {
+			r.Healthy = info.Healthy
+		}
+
+		// bind mounts of a client mount show the same filesystem
+		if r.Kind == mountKindRepository || r.Kind == mountKindIsolated {
+			for _, o := range mounts {
+				if o.MajorMinor != m.MajorMinor || o.MountPoint == m.MountPoint || contains(r.Dependents, o.MountPoint) {
+					continue
+				}
+				r.Dependents = append(r.Dependents, o.MountPoint)
+				if ow, ok := owners.of(o.MountPoint); ok && !contains(r.VolumeIDs, ow.v.VolumeID) {
+					r.VolumeIDs = append(r.VolumeIDs, ow.v.VolumeID)
+				}
+			}
+		}
+		reports = append(reports, r)
+	}
+	return reports, nil
+}
+
+// mountOwner is the volume a tracked mount point belongs to
+type mountOwner struct {
+	kind string
+	v    *volumeState
+}
+
+// mountOwners maps the mount points the node server tracks to their volumes
+type mountOwners map[string]mountOwner
+
+func (a *Admin) mountOwners(volumes []*volumeState) mountOwners {
+	owners := mountOwners{}
+	for _, v := range volumes {
+		owners[filepath.Clean(v.StagingTargetPath)] = mountOwner{mountKindStaging, v}
+		for t := range v.Targets {
+			owners[filepath.Clean(t)] = mountOwner{mountKindTarget, v}
+		}
+		if v.Isolated {
+			owners[a.d.isolatedVolume(v.VolumeID).mountPath()] = mountOwner{mountKindIsolated, v}
+		}
+	}
+	return owners
+}
+
+// of returns the owner of a mount point, or of the tracked path it lies below
+func (owners mountOwners) of(mountpoint string) (mountOwner, bool) {
+	for p := mountpoint; p != "/" && p != "."; p = filepath.Dir(p) {
+		if o, ok := owners[p]; ok {
+			return o, true
+		}
+	}
+	return mountOwner{}, false
+}
+
+// Repositories returns the repositories mounted on /cvmfs, with the status their clients report
+func (a *Admin) Repositories() ([]RepositoryReport, error) {
+	mounts, err := a.Mounts()
+	if err != nil {
+		return nil, err
+	}
+
+	reports := []RepositoryReport{}
+	for _, m := range mounts {
+		if m.Kind != mountKindRepository || filepath.Dir(m.MountPoint) != AutomountRoot {
+			continue
+		}
+		r := a.repositoryReport(Repository(m.Repository))
+		r.Mounted = true
+		r.Healthy = m.Healthy
+		r.VolumeIDs = m.VolumeIDs
+		reports = append(reports, r)
+	}
+	return reports, nil
+}
+
+// repositoryReport asks the client of a repository mounted on /cvmfs for its status
+func (a *Admin) repositoryReport(r Repository) RepositoryReport {
+	report := RepositoryReport{
+		Repository: string(r),
+		MountPoint: r.getMountPath(),
+		CacheBase:  a.d.repositoryCacheBase(r),
+	}
+	st, err := a.d.talk(r).Status()
+	if err != nil {
+		report.Error = err.Error()
+		return report
+	}
+	report.Revision = st.Revision
+	report.RootHash = st.RootHash
+	report.Proxy = st.Proxy
+	report.Host = st.Host
+	report.CacheUsed = st.Cache.Unpinned
+	report.CachePinned = st.Cache.Pinned
+	return report
+}
+
+// Probe accesses a repository on /cvmfs, which mounts it when the node runs autofs,
+// and asks its client for its status
+func (a *Admin) Probe(r Repository) (*RepositoryReport, error) {
+	if err := r.Validate(); err != nil {
+		return nil, err
+	}
+	mountpoint := r.getMountPath()
+
+	// accessing a repository whose client hangs would block forever
+	done := make(chan error, 1)
+	go func() {
+		_, err := os.ReadDir(mountpoint)
+		done <- err
+	}()
+	var accessErr error
+	select {
+	case accessErr = <-done:
+	case <-time.After(probeTimeout):
+		return &RepositoryReport{
+			Repository: string(r),
+			MountPoint: mountpoint,
+			Error:      fmt.Sprintf("no answer from %s within %s", mountpoint, probeTimeout),
+		}, nil
+	}
+
+	info, err := inspectMount(mountpoint)
+	if err != nil {
+		return nil, err
+	}
+	report := &RepositoryReport{Repository: string(r), MountPoint: mountpoint}
+	if info == nil || !info.isCVMFS() {
+		report.Error = "not mounted"
+		if accessErr != nil {
+			report.Error = accessErr.Error()
+		}
+		return report, nil
+	}
+
+	*report = a.repositoryReport(r)
+	report.Mounted = true
+	report.Healthy = info.Healthy && accessErr == nil
+	if accessErr != nil {
+		report.Error = accessErr.Error()
+	}
+	return report, nil
+}
+
+// Refresh makes the clients of repository r switch to its latest revision, which is how
+// volumes with the manual refresh policy are updated: the mount on /cvmfs, and the
+// isolated clients of volumes using r. Clients of pinned volumes are left alone.
+func (a *Admin) Refresh(r Repository) ([]RefreshReport, error) {
+	if err := r.Validate(); err != nil {
+		return nil, err
+	}
+	volumes, err := a.d.state.List()
+	if err != nil {
+		return nil, err
+	}
+
+	shared := RefreshReport{MountPoint: r.getMountPath()}
+	var reports []RefreshReport
+	for _, v := range volumes {
+		o := v.Options
+		if o.Automount || o.Repository != r || o.RefreshPolicy == RefreshPinned {
+			continue
+		}
+		if v.Isolated {
+			iv := a.d.isolatedVolume(v.VolumeID)
+			report := RefreshReport{MountPoint: iv.mountPath(), VolumeIDs: []string{v.VolumeID}}
+			reports = append(reports, refreshReport(r, report, iv.talk(r)))
+			continue
+		}
+		shared.VolumeIDs = append(shared.VolumeIDs, v.VolumeID)
+	}
+	if len(shared.VolumeIDs) > 0 {
+		reports = append([]RefreshReport{refreshReport(r, shared, a.d.talk(r))}, reports...)
+	}
+	if len(reports) == 0 {
+		return nil, fmt.Errorf("no staged volume follows repository %s", r)
+	}
+	return reports, nil
+}
+
+// refreshReport refreshes a client and fills in the outcome
+func refreshReport(r Repository, report RefreshReport, t *talkClient) RefreshReport {
+	var err error
+	report.From, report.To, err = refreshClient(r, t)
+	if err != nil {
+		report.Error = err.Error()
+	}
+	return report
+}
+
+// Caches returns the cache directories below the cache folder and of isolated volumes,
+// along with the mounted repositories using them
+func (a *Admin) Caches() ([]CacheReport, error) {
+	mounts, err := a.Mounts()
+	if err != nil {
+		return nil, err
+	}
+	return a.caches(mounts)
+}
+
+func (a *Admin) caches(mounts []MountReport) ([]CacheReport, error) {
+	c := a.d.config
+	dirs := []string{filepath.Clean(c.CacheFolder)}
+	for _, sub := range []string{cacheRepositoriesDir, cacheGroupsDir, preloadedCachesDir} {
+		entries, _ := filepath.Glob(filepath.Join(c.CacheFolder, sub, "*"))
+		dirs = append(dirs, entries...)
+	}
+	isolated, _ := filepath.Glob(filepath.Join(c.StateDir, isolatedVolumesDir, "*", "cache"))
+	dirs = append(dirs, isolated...)
+
+	users := map[string][]string{}
+	for _, m := range mounts {
+		var base string
+		switch {
+		case m.Kind == mountKindRepository && filepath.Dir(m.MountPoint) == AutomountRoot:
+			base = filepath.Clean(a.d.repositoryCacheBase(Repository(m.Repository)))
+		case m.Kind == mountKindIsolated:
+			base = filepath.Join(filepath.Dir(m.MountPoint), "cache")
+		default:
+			continue
+		}
+		users[base] = append(users[base], m.Repository)
+	}
+
+	reports := []CacheReport{}
+	for _, dir := range dirs {
+		fi, err := os.Stat(dir)
+		if err != nil || !fi.IsDir() {
+			continue
+		}
+		// the dedicated caches are reported on their own
+		size, err := diskUsage(dir, dir == filepath.Clean(c.CacheFolder))
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, CacheReport{Path: dir, Bytes: size, Repositories: users[dir]})
+	}
+	return reports, nil
+}
+
+// diskUsage returns the size of the files below dir, leaving out
+// the directories of dedicated caches if skipDedicated is set
+func diskUsage(dir string, skipDedicated bool) (int64, error) {
+	var size int64
+	err := filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			// caches change while they are walked
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if fi.IsDir() && skipDedicated && filepath.Dir(p) == dir {
+			switch fi.Name() {
+			case cacheRepositoriesDir, cacheGroupsDir, preloadedCachesDir:
+				return filepath.SkipDir
+			}
+		}
+		if fi.Mode().IsRegular() {
+			size += fi.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+// Status summarizes the volumes, mounts and caches of the node
+func (a *Admin) Status() (*StatusReport, error) {
+	volumes, err := a.d.state.List()
+	if err != nil {
+		return nil, err
+	}
+	mounts, err := a.Mounts()
+	if err != nil {
+		return nil, err
+	}
+	caches, err := a.caches(mounts)
+	if err != nil {
+		return nil, err
+	}
+
+	st := &StatusReport{DriverName: a.d.config.DriverName, StagedVolumes: len(volumes)}
+	for _, v := range volumes {
+		st.PublishedTargets += len(v.Targets)
+		if err := statMountPoint(v.StagingTargetPath); os.IsNotExist(err) {
+			st.VolumesWithoutDir++
+		}
+	}
+	for _, m := range mounts {
+		switch m.Kind {
+		case mountKindRepository:
+			if filepath.Dir(m.MountPoint) == AutomountRoot {
+				st.Repositories++
+			}
+		case mountKindIsolated:
+			st.IsolatedClients++
+		case mountKindUntracked:
+			st.UntrackedMounts++
+		}
+		if !m.Healthy {
+			st.BrokenMounts++
+		}
+	}
+	for _, c := range caches {
+		st.CacheBytes += c.Bytes
+	}
+	return st, nil
+}
+
+// Ready asks the running node plugin whether the node can serve volumes.
+// It is run by the readiness probe of the plugin container.
+func (a *Admin) Ready() error {
+	return queryReadiness(a.d.config.StateDir)
+}
+
+// Cleanup unmounts the untracked mounts the node plugin would remove at startup,
+// and forgets volumes whose staging path is gone. With dryRun it only reports them.
+func (a *Admin) Cleanup(dryRun bool) ([]CleanupAction, error) {
+	log := internal.GetLogger("cleanup")
+
+	volumes, err := a.d.state.List()
+	if err != nil {
+		return nil, err
+	}
+	orphans, err := a.d.orphanMounts(log, knownPaths(volumes))
+	if err != nil {
+		return nil, err
+	}
+
+	actions := []CleanupAction{}
+	for _, v := range volumes {
+		// taken before probing, so the volume is only forgotten if the running
+		// driver has not written its state since, e.g. when staging it again
+		seen, err := a.d.state.Stat(v.VolumeID)
+		if err != nil {
+			continue
+		}
+		// a hung mount is not gone, and is left to the driver to remount
+		if err := statMountPoint(v.StagingTargetPath); !os.IsNotExist(err) {
+			continue
+		}
+		action := CleanupAction{Action: "forget", Path: v.StagingTargetPath, VolumeID: v.VolumeID, Reason: "staging path is gone"}
+		if !dryRun {
+			if err := a.forgetVolume(v.VolumeID, seen); err != nil {
+				action.Error = err.Error()
+			}
+		}
+		actions = append(actions, action)
+	}
+
+	for _, o := range orphans {
+		if !o.removable() {
+			continue
+		}
+		action := CleanupAction{Action: "unmount", Path: o.Path, VolumeID: o.VolumeID, Reason: "unknown to kubelet"}
+		if o.VolumeID != "" {
+			action.Reason = "broken and untracked"
+		}
+		if !dryRun {
+			if err := lazyUnmount(o.Path); err != nil {
+				action.Error = err.Error()
+			}
+		}
+		actions = append(actions, action)
+	}
+	return actions, nil
+}
+
+// forgetVolume forgets a volume whose staging path was found gone, like the driver does
+// at startup. The running driver may have staged the volume again meanwhile, so it is
+// only forgotten if its state is still the one seen before looking at the staging path.
+func (a *Admin) forgetVolume(volID string, seen os.FileInfo) error {
+	deleted, err := a.d.state.DeleteUnchanged(volID, seen)
+	if err != nil || !deleted {
+		return err
+	}
+	return a.d.removeIsolatedVolume(volID)
+}
+
+// contains reports whether list has s
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
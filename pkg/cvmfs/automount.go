@@ -0,0 +1,103 @@
+// Copyright CERN.
+//
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cvmfs
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/cernops/cvmfs-csi/internal"
+)
+
+const (
+	// AutomountRoot is where autofs exposes all repositories
+	AutomountRoot = "/cvmfs"
+	// autofs master map that maps AutomountRoot onto the cvmfs program map
+	automountMasterMap = "/etc/auto.master.cvmfs"
+	// program map shipped with the cvmfs client package
+	automountProgramMap = "/etc/auto.cvmfs"
+)
+
+// startAutomount starts the autofs daemon on AutomountRoot, unless it is
+// already running from a previous plugin instance.
+// AutomountRoot is made a shared mount, so repositories that get mounted on
+// access propagate into every bind mount of it.
+func startAutomount() error {
+	log := internal.GetLogger("startAutomount").With().Str("path", AutomountRoot).Logger()
+
+	fstype, err := mountFsType(AutomountRoot)
+	if err != nil {
+		return fmt.Errorf("cannot probe mount table: %w", err)
+	}
+
+	if fstype == "autofs" {
+		log.Debug().Msg("autofs already running")
+	} else {
+		if err := mkdir(AutomountRoot); err != nil {
+			return fmt.Errorf("cannot create automount root %s: %w", AutomountRoot, err)
+		}
+
+		master := fmt.Sprintf("%s %s\n", AutomountRoot, automountProgramMap)
+		if err := os.WriteFile(automountMasterMap, []byte(master), 0644); err != nil {
+			return fmt.Errorf("cannot write autofs master map %s: %w", automountMasterMap, err)
+		}
+
+		log.Debug().Msg("starting autofs")
+		if _, err := execCommand("/usr/sbin/automount", automountMasterMap); err != nil {
+			return fmt.Errorf("cannot start autofs: %w", err)
+		}
+		log.Info().Msg("autofs started")
+	}
+
+	if _, err := execCommand("mount", "--make-shared", AutomountRoot); err != nil {
+		return fmt.Errorf("cannot make %s a shared mount: %w", AutomountRoot, err)
+	}
+
+	return nil
+}
+
+// startAutomount starts autofs in the mount holder, if there is one, so that
+// the clients autofs runs survive restarts of the node plugin
+func (d *Driver) startAutomount() error {
+	if d.holder != nil {
+		return d.holder.StartAutomount()
+	}
+	return startAutomount()
+}
+
+// triggerAutomount makes autofs mount the given repository by accessing it
+func triggerAutomount(r Repository) error {
+	to := r.getMountPath()
+	log := internal.GetLogger("triggerAutomount").With().Str("to", to).Str("repository", string(r)).Logger()
+	log.Debug().Msg("accessing repository")
+
+	// autofs answers ENOENT for repositories it cannot mount
+	if _, err := os.Stat(to); err != nil {
+		return newMountError(r, "", err)
+	}
+
+	info, err := inspectMount(to)
+	if err != nil {
+		return fmt.Errorf("cannot probe if repository is mounted: %w", err)
+	}
+	if info == nil || !info.isCVMFS() {
+		return fmt.Errorf("repository %s was not mounted by autofs", r)
+	}
+
+	log.Info().Msg("mounted")
+	return nil
+}
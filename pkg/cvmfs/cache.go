@@ -0,0 +1,294 @@
+// Copyright CERN.
+//
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cvmfs
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cernops/cvmfs-csi/internal"
+)
+
+// CachePolicy decides which repositories share a cache directory
+type CachePolicy string
+
+const (
+	// CacheShared keeps all repositories in CacheFolder, unless a volume selects a cache group
+	CacheShared CachePolicy = "shared"
+	// CachePerRepository gives every repository its own cache directory
+	CachePerRepository CachePolicy = "per-repository"
+)
+
+func (p *CachePolicy) String() string {
+	return string(*p)
+}
+
+// Set implements flag.Value
+func (p *CachePolicy) Set(s string) error {
+	switch CachePolicy(s) {
+	case CacheShared, CachePerRepository:
+		*p = CachePolicy(s)
+		return nil
+	}
+	return fmt.Errorf("unknown cache policy %s", s)
+}
+
+// CacheType selects the cache manager of the clients
+type CacheType string
+
+const (
+	// CachePosix keeps the cache in CacheFolder, managed by the clients
+	CachePosix CacheType = "posix"
+	// CacheAlien keeps the cache in AlienCacheDir, which the clients do not manage
+	CacheAlien CacheType = "alien"
+	// CacheRAM keeps the cache in memory
+	CacheRAM CacheType = "ram"
+	// CacheTiered reads through a RAM or posix upper tier from an alien lower tier
+	CacheTiered CacheType = "tiered"
+)
+
+func (t *CacheType) String() string {
+	return string(*t)
+}
+
+// Set implements flag.Value
+func (t *CacheType) Set(s string) error {
+	switch CacheType(s) {
+	case CachePosix, CacheAlien, CacheRAM, CacheTiered:
+		*t = CacheType(s)
+		return nil
+	}
+	return fmt.Errorf("unknown cache type %s", s)
+}
+
+// validateCacheConfig checks the cache settings of the driver for consistency, filling in defaults
+func validateCacheConfig(c *DriverConfig) error {
+	if c.CachePolicy == "" {
+		c.CachePolicy = CacheShared
+	}
+	if err := c.CachePolicy.Set(string(c.CachePolicy)); err != nil {
+		return err
+	}
+	if c.CacheType == "" {
+		c.CacheType = CachePosix
+	}
+	if err := c.CacheType.Set(string(c.CacheType)); err != nil {
+		return err
+	}
+
+	switch c.CacheType {
+	case CacheAlien, CacheTiered:
+		if c.AlienCacheDir == "" {
+			return fmt.Errorf("cache type %s requires an alien cache directory", c.CacheType)
+		}
+		fi, err := os.Stat(c.AlienCacheDir)
+		if err != nil {
+			return fmt.Errorf("cannot access alien cache: %w", err)
+		}
+		if !fi.IsDir() {
+			return fmt.Errorf("alien cache %s is not a directory", c.AlienCacheDir)
+		}
+	case CacheRAM:
+		if c.RAMCacheSize == 0 {
+			return fmt.Errorf("cache type ram requires a RAM cache size")
+		}
+	}
+
+	if c.AlienCacheDir != "" && c.CacheType != CacheAlien && c.CacheType != CacheTiered {
+		return fmt.Errorf("an alien cache directory requires cache type alien or tiered")
+	}
+	if c.AlienCacheReadOnly && c.CacheType != CacheTiered {
+		return fmt.Errorf("only the lower tier of a tiered cache can be read-only")
+	}
+	if c.RAMCacheSize != 0 && c.CacheType != CacheRAM && c.CacheType != CacheTiered {
+		return fmt.Errorf("a RAM cache size requires cache type ram or tiered")
+	}
+
+	// dedicated caches are separate posix cache directories
+	if !c.dedicatedCaches() && c.CachePolicy != CacheShared {
+		return fmt.Errorf("cache policy %s requires a posix cache", c.CachePolicy)
+	}
+	if c.CacheQuota != 0 && !c.hasPosixCache() {
+		return fmt.Errorf("a cache quota requires a posix cache, or a tiered cache with a posix upper tier")
+	}
+	return nil
+}
+
+// hasPosixCache reports whether the clients manage a cache in CacheFolder
+func (c *DriverConfig) hasPosixCache() bool {
+	return c.CacheType == CachePosix || (c.CacheType == CacheTiered && c.RAMCacheSize == 0)
+}
+
+// dedicatedCaches reports whether repositories can get their own cache directory
+func (c *DriverConfig) dedicatedCaches() bool {
+	return c.CacheType == CachePosix
+}
+
+// Dedicated cache directories live below CacheFolder, next to the shared cache
+const (
+	cacheRepositoriesDir = "repositories"
+	cacheGroupsDir       = "groups"
+)
+
+// cacheCleanupInterval is how often unused cache directories are removed
+const cacheCleanupInterval = 10 * time.Minute
+
+// cacheBase returns the cache directory of the repository of a volume,
+// and whether it is dedicated to the repository or its cache group
+func (d *Driver) cacheBase(opts *VolumeOptions) (string, bool) {
+	switch {
+	case opts.CacheGroup != "":
+		return filepath.Join(d.config.CacheFolder, cacheGroupsDir, opts.CacheGroup), true
+	case d.config.CachePolicy == CachePerRepository:
+		return filepath.Join(d.config.CacheFolder, cacheRepositoriesDir, string(opts.Repository)), true
+	}
+	return d.config.CacheFolder, false
+}
+
+// repositoryCacheBase returns the cache directory a repository was configured with
+func (d *Driver) repositoryCacheBase(r Repository) string {
+	if v, ok := repositoryConfigValue(r, "CVMFS_CACHE_BASE"); ok {
+		return v
+	}
+	return d.config.CacheFolder
+}
+
+// repositoryConfigValue returns a setting of the configuration the driver generated for a repository
+func repositoryConfigValue(r Repository, key string) (string, bool) {
+	f, err := os.Open(r.getConfigPath())
+	if err != nil {
+		return "", false
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if v := strings.TrimPrefix(scanner.Text(), key+"="); v != scanner.Text() {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// runCacheCleaner periodically removes the dedicated caches of repositories
+// no volume uses anymore. It never returns.
+func (d *Driver) runCacheCleaner() {
+	log := internal.GetLogger("cacheCleaner")
+
+	ticker := time.NewTicker(cacheCleanupInterval)
+	defer ticker.Stop()
+	for {
+		if err := d.cleanupCaches(); err != nil {
+			log.Error().Err(err).Msg("cache cleanup failed")
+			d.lastErrors.add("cacheCleaner", "", err)
+		}
+		<-ticker.C
+	}
+}
+
+// cleanupCaches unmounts repositories with a dedicated or extracted preloaded cache that
+// no staged volume uses, then removes such cache directories no mounted repository uses
+func (d *Driver) cleanupCaches() error {
+	log := internal.GetLogger("cleanupCaches")
+
+	d.mountMu.Lock()
+	defer d.mountMu.Unlock()
+
+	volumes, err := d.state.List()
+	if err != nil {
+		return err
+	}
+	used := map[Repository]bool{CVMFSConfigRepo: true}
+	inUse := map[string]bool{}
+	for _, v := range volumes {
+		if v.Options.Automount || v.Isolated {
+			continue
+		}
+		used[v.Options.Repository] = true
+		base, _ := d.cacheBase(&v.Options)
+		inUse[base] = true
+		if v.Options.Preload != "" {
+			inUse[filepath.Join(d.config.CacheFolder, preloadedCachesDir, string(v.Options.Repository))] = true
+		}
+	}
+
+	mounts, err := listMounts()
+	if err != nil {
+		return err
+	}
+
+	for _, m := range mounts {
+		if !m.isCVMFS() || filepath.Dir(m.MountPoint) != AutomountRoot {
+			continue
+		}
+		r := Repository(filepath.Base(m.MountPoint))
+		base := filepath.Clean(d.repositoryCacheBase(r))
+		alien, _ := repositoryConfigValue(r, "CVMFS_ALIEN_CACHE")
+		alien = filepath.Clean(alien)
+		dedicated := base != filepath.Clean(d.config.CacheFolder) ||
+			filepath.Dir(alien) == filepath.Join(d.config.CacheFolder, preloadedCachesDir)
+
+		// autofs unmounts idle repositories by itself
+		if !used[r] && !d.config.Automount && dedicated && !hasBindMounts(mounts, m) {
+			log.Info().Str("repository", string(r)).Str("cache", base).Msg("unmounting unused repository")
+			if err := Unmount(m.MountPoint); err != nil {
+				log.Error().Err(err).Str("repository", string(r)).Msg("cannot unmount unused repository")
+			} else {
+				continue
+			}
+		}
+		inUse[base] = true
+		inUse[alien] = true
+	}
+
+	for _, sub := range []string{cacheRepositoriesDir, cacheGroupsDir, preloadedCachesDir} {
+		dir := filepath.Join(d.config.CacheFolder, sub)
+		entries, err := os.ReadDir(dir)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		for _, e := range entries {
+			p := filepath.Join(dir, e.Name())
+			if !e.IsDir() || inUse[p] {
+				continue
+			}
+			log.Info().Str("path", p).Msg("removing unused cache")
+			if err := os.RemoveAll(p); err != nil {
+				log.Error().Err(err).Str("path", p).Msg("cannot remove unused cache")
+			}
+		}
+	}
+	return nil
+}
+
+// hasBindMounts reports whether any other mount shows the filesystem of m,
+// such as the staging paths of volumes
+func hasBindMounts(mounts []mountEntry, m mountEntry) bool {
+	for _, o := range mounts {
+		if o.ID != m.ID && o.MajorMinor == m.MajorMinor {
+			return true
+		}
+	}
+	return false
+}
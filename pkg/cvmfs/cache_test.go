@@ -0,0 +1,125 @@
+// Copyright CERN.
+//
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cvmfs
+
+import "testing"
+
+func TestCachePolicySet(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    CachePolicy
+		wantErr bool
+	}{
+		{value: "shared", want: CacheShared},
+		{value: "per-repository", want: CachePerRepository},
+		{value: "per-volume", wantErr: true},
+		{value: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		var p CachePolicy
+		err := p.Set(tt.value)
+		if (err != nil) != tt.wantErr || p != tt.want {
+			t.Errorf("Set(%q) = %q, %v, want %q, wantErr %v", tt.value, p, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestCacheBase(t *testing.T) {
+	tests := []struct {
+		name          string
+		policy        CachePolicy
+		opts          VolumeOptions
+		want          string
+		wantDedicated bool
+	}{
+		{name: "shared", policy: CacheShared, opts: VolumeOptions{Repository: "atlas.cern.ch"}, want: "/var/cache/cvmfs"},
+		{
+			name:          "per repository",
+			policy:        CachePerRepository,
+			opts:          VolumeOptions{Repository: "atlas.cern.ch"},
+			want:          "/var/cache/cvmfs/repositories/atlas.cern.ch",
+			wantDedicated: true,
+		},
+		{
+			name:          "cache group",
+			policy:        CacheShared,
+			opts:          VolumeOptions{Repository: "atlas.cern.ch", CacheGroup: "analysis"},
+			want:          "/var/cache/cvmfs/groups/analysis",
+			wantDedicated: true,
+		},
+		{
+			name:          "cache group over per repository",
+			policy:        CachePerRepository,
+			opts:          VolumeOptions{Repository: "atlas.cern.ch", CacheGroup: "analysis"},
+			want:          "/var/cache/cvmfs/groups/analysis",
+			wantDedicated: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &Driver{config: DriverConfig{CacheFolder: "/var/cache/cvmfs", CachePolicy: tt.policy}}
+			got, dedicated := d.cacheBase(&tt.opts)
+			if got != tt.want || dedicated != tt.wantDedicated {
+				t.Errorf("cacheBase() = %s, %t, want %s, %t", got, dedicated, tt.want, tt.wantDedicated)
+			}
+		})
+	}
+}
+
+func TestCacheVolumeOptions(t *testing.T) {
+	tests := []struct {
+		name      string
+		context   map[string]string
+		wantGroup string
+		wantQuota uint64
+		wantErr   bool
+	}{
+		{name: "group and quota", context: map[string]string{"cacheGroup": "analysis", "cacheQuota": "4000"}, wantGroup: "analysis", wantQuota: 4000},
+		{name: "invalid group", context: map[string]string{"cacheGroup": "../shared"}, wantErr: true},
+		{name: "invalid quota", context: map[string]string{"cacheQuota": "4G"}, wantErr: true},
+		{name: "negative quota", context: map[string]string{"cacheQuota": "-1"}, wantErr: true},
+		{name: "automount with group", context: map[string]string{"automount": "true", "cacheGroup": "analysis"}, wantErr: true},
+		{name: "automount with quota", context: map[string]string{"automount": "true", "cacheQuota": "4000"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, ok := tt.context["automount"]; !ok {
+				tt.context["repository"] = "atlas.cern.ch"
+			}
+			got, err := VolumeOptionsFromContext(tt.context)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("VolumeOptionsFromContext() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && (got.CacheGroup != tt.wantGroup || got.CacheQuota != tt.wantQuota) {
+				t.Errorf("VolumeOptionsFromContext() = group %s, quota %d, want %s, %d", got.CacheGroup, got.CacheQuota, tt.wantGroup, tt.wantQuota)
+			}
+		})
+	}
+}
+
+func TestHasBindMounts(t *testing.T) {
+	repository := mountEntry{ID: 10, MajorMinor: "0:62", MountPoint: "/cvmfs/atlas.cern.ch"}
+	other := mountEntry{ID: 11, MajorMinor: "0:63", MountPoint: "/cvmfs/cms.cern.ch"}
+	staging := mountEntry{ID: 20, MajorMinor: "0:62", MountPoint: "/var/lib/kubelet/plugins/kubernetes.io/csi/pv/pv-1/globalmount"}
+
+	if hasBindMounts([]mountEntry{repository, other}, repository) {
+		t.Error("hasBindMounts() without bind mounts = true")
+	}
+	if !hasBindMounts([]mountEntry{repository, other, staging}, repository) {
+		t.Error("hasBindMounts() with a staging path = false")
+	}
+}
@@ -44,15 +44,21 @@ func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 		return nil, status.Error(codes.InvalidArgument, fmt.Errorf("invalid CreateVolumeRequest: %w", err).Error())
 	}
 
+	topologies, err := d.volumeTopology(req.GetAccessibilityRequirements())
+	if err != nil {
+		return nil, status.Error(codes.ResourceExhausted, err.Error())
+	}
+
 	volId := newVolumeID()
 
-	log.Info().Str("volumeid", string(volId)).Msg("new volume created")
+	log.Info().Str("volumeid", string(volId)).Interface("topologies", topologies).Msg("new volume created")
 
 	return &csi.CreateVolumeResponse{
 		Volume: &csi.Volume{
-			VolumeId:      string(volId),
-			VolumeContext: req.GetParameters(),
-			CapacityBytes: req.GetCapacityRange().GetRequiredBytes(),
+			VolumeId:           string(volId),
+			VolumeContext:      req.GetParameters(),
+			CapacityBytes:      req.GetCapacityRange().GetRequiredBytes(),
+			AccessibleTopology: topologies,
 		},
 	}, nil
 }
@@ -81,22 +87,37 @@ func (d *Driver) ControllerGetCapabilities(ctx context.Context, req *csi.Control
 // ValidateVolumeCapabilities is used to verify that Kubernetes is creating
 // volumes that this driver actually understands
 // For this CVMFS driver, this means that we want to deal with volumes
-// that represent readonly block storage
+// that represent readonly filesystems
+// Capabilities we cannot serve are not an error, but result in a response
+// without confirmation, as mandated by the CSI spec
 func (d *Driver) ValidateVolumeCapabilities(ctx context.Context, req *csi.ValidateVolumeCapabilitiesRequest) (*csi.ValidateVolumeCapabilitiesResponse, error) {
-	if err := d.validateVolumeCapabilities(req.VolumeCapabilities); err != nil {
-		return nil, status.Error(codes.Unimplemented, err.Error())
+	log := zerolog.Ctx(ctx).With().Str("volumeid", req.GetVolumeId()).Logger()
+	if err := validateValidateVolumeCapabilitiesRequest(req); err != nil {
+		return nil, status.Error(codes.InvalidArgument, fmt.Errorf("invalid ValidateVolumeCapabilitiesRequest: %w", err).Error())
+	}
+
+	if err := d.validateVolumeCapabilities(req.GetVolumeCapabilities()); err != nil {
+		log.Debug().Err(err).Msg("volume capabilities not confirmed")
+		return &csi.ValidateVolumeCapabilitiesResponse{Message: err.Error()}, nil
+	}
+
+	if _, err := VolumeOptionsFromContext(req.GetVolumeContext()); err != nil {
+		log.Debug().Err(err).Msg("volume context not confirmed")
+		return &csi.ValidateVolumeCapabilitiesResponse{Message: err.Error()}, nil
 	}
 
 	return &csi.ValidateVolumeCapabilitiesResponse{
 		Confirmed: &csi.ValidateVolumeCapabilitiesResponse_Confirmed{
-			VolumeCapabilities: d.VolumeCapabilities,
+			VolumeContext:      req.GetVolumeContext(),
+			VolumeCapabilities: req.GetVolumeCapabilities(),
+			Parameters:         req.GetParameters(),
 		},
 	}, nil
 }
 
 func (d *Driver) validateVolumeCapabilities(capabilities []*csi.VolumeCapability) error {
 	log := internal.GetLogger("validateVolumeCapabilities")
-	if capabilities == nil {
+	if len(capabilities) == 0 {
 		return fmt.Errorf("volume capabilities cannot be empty")
 	}
 	for _, c := range capabilities {
@@ -141,6 +162,17 @@ func (d *Driver) validateCreateVolumeRequest(req *csi.CreateVolumeRequest) error
 	return nil
 }
 
+func validateValidateVolumeCapabilitiesRequest(req *csi.ValidateVolumeCapabilitiesRequest) error {
+	if req.GetVolumeId() == "" {
+		return fmt.Errorf("volume ID cannot be empty")
+	}
+
+	if len(req.GetVolumeCapabilities()) == 0 {
+		return fmt.Errorf("volume capabilities cannot be empty")
+	}
+	return nil
+}
+
 func (d *Driver) validateDeleteVolumeRequest(req *csi.DeleteVolumeRequest) error {
 	if req.VolumeId == "" {
 		return fmt.Errorf("volume ID cannot be empty")
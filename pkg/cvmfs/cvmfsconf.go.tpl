@@ -4,6 +4,45 @@
 CVMFS_CACHE_BASE={{ .CacheFolder }}
 {{ end }}
 
+{{- if eq .CacheType "posix" }}
+{{- if .CacheQuota }}
+CVMFS_QUOTA_LIMIT={{ .CacheQuota }}
+{{ end }}
+{{- else }}
+{{- /* keep the control sockets where the driver expects them */}}
+CVMFS_WORKSPACE={{ .CacheFolder }}/shared
+{{- if eq .CacheType "alien" }}
+CVMFS_ALIEN_CACHE={{ .AlienCacheDir }}
+CVMFS_SHARED_CACHE=no
+CVMFS_QUOTA_LIMIT=-1
+{{ else if eq .CacheType "ram" }}
+CVMFS_CACHE_PRIMARY=ram
+CVMFS_CACHE_ram_TYPE=ram
+CVMFS_CACHE_ram_SIZE={{ .RAMCacheSize }}
+{{ else if eq .CacheType "tiered" }}
+CVMFS_CACHE_PRIMARY=tiered
+CVMFS_CACHE_tiered_TYPE=tiered
+CVMFS_CACHE_tiered_UPPER=upper
+CVMFS_CACHE_tiered_LOWER=lower
+CVMFS_CACHE_tiered_LOWER_READONLY={{ if .AlienCacheReadOnly }}yes{{ else }}no{{ end }}
+{{- if .RAMCacheSize }}
+CVMFS_CACHE_upper_TYPE=ram
+CVMFS_CACHE_upper_SIZE={{ .RAMCacheSize }}
+{{- else }}
+CVMFS_CACHE_upper_TYPE=posix
+CVMFS_CACHE_upper_BASE={{ .CacheFolder }}
+CVMFS_CACHE_upper_SHARED=yes
+{{- if .CacheQuota }}
+CVMFS_CACHE_upper_QUOTA_LIMIT={{ .CacheQuota }}
+{{- end }}
+{{- end }}
+CVMFS_CACHE_lower_TYPE=posix
+CVMFS_CACHE_lower_ALIEN={{ .AlienCacheDir }}
+CVMFS_CACHE_lower_SHARED=no
+CVMFS_CACHE_lower_QUOTA_LIMIT=-1
+{{ end }}
+{{- end }}
+
 {{- if .Proxy }}
 CVMFS_HTTP_PROXY={{ .Proxy }}
 {{ end }}
\ No newline at end of file
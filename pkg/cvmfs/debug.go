@@ -0,0 +1,188 @@
+// Copyright CERN.
+//
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cvmfs
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/pprof"
+	"reflect"
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cernops/cvmfs-csi/internal"
+)
+
+// recentErrorsSize is how many errors the debug endpoint shows
+const recentErrorsSize = 100
+
+// errorEntry is an error the driver ran into, e.g. a failed gRPC call or refresh
+type errorEntry struct {
+	Time time.Time `json:"time"`
+	// Source is the gRPC method, or the background task that failed
+	Source   string `json:"source"`
+	VolumeID string `json:"volumeID,omitempty"`
+	Error    string `json:"error"`
+}
+
+// recentErrors keeps the last errors of the driver, for the debug endpoint
+type recentErrors struct {
+	mu      sync.Mutex
+	entries []errorEntry
+	next    int
+}
+
+func (e *recentErrors) add(source, volID string, err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	entry := errorEntry{Time: time.Now(), Source: source, VolumeID: volID, Error: err.Error()}
+	if len(e.entries) < recentErrorsSize {
+		e.entries = append(e.entries, entry)
+		return
+	}
+	e.entries[e.next] = entry
+	e.next = (e.next + 1) % recentErrorsSize
+}
+
+// list returns the errors, most recent first
+func (e *recentErrors) list() []errorEntry {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	list := make([]errorEntry, 0, len(e.entries))
+	for i := len(e.entries) - 1; i >= 0; i-- {
+		list = append(list, e.entries[(e.next+i)%len(e.entries)])
+	}
+	return list
+}
+
+// recordGRPCError keeps failed gRPC calls among the recent errors
+func (d *Driver) recordGRPCError(method string, req interface{}, err error) {
+	// kubelet probes frequently, the debug state shows why the node is not ready anyway
+	if method == "/csi.v1.Identity/Probe" {
+		return
+	}
+	volID := ""
+	if r, ok := req.(interface{ GetVolumeId() string }); ok {
+		volID = r.GetVolumeId()
+	}
+	d.lastErrors.add(method, volID, err)
+}
+
+// bootstrapStatus tells whether the node plugin finished starting up, and if it can serve volumes
+type bootstrapStatus struct {
+	Ready bool `json:"ready"`
+	// NotReady explains why the node cannot serve volumes
+	NotReady string `json:"notReady,omitempty"`
+	// Reconciled is set once the mounts of a previous plugin instance were restored
+	Reconciled bool `json:"reconciled"`
+	// Unavailable explains why the node is reported as unavailable in its topology
+	Unavailable string `json:"unavailable,omitempty"`
+}
+
+// debugState is what the debug endpoint shows about the driver
+type debugState struct {
+	DriverName string          `json:"driverName"`
+	Version    string          `json:"version"`
+	NodeID     string          `json:"nodeID"`
+	Bootstrap  bootstrapStatus `json:"bootstrap"`
+	Config     DriverConfig    `json:"config"`
+	// Repositories are the repository and isolated client mounts
+	Repositories []MountReport  `json:"repositories"`
+	Volumes      []VolumeReport `json:"volumes"`
+	Errors       []errorEntry   `json:"errors"`
+	// StateError tells why Repositories or Volumes are missing
+	StateError string `json:"stateError,omitempty"`
+}
+
+// credentialsRegexp matches the user information of URLs, e.g. of authenticated proxies
+var credentialsRegexp = regexp.MustCompile(`://[^/@;|]*@`)
+
+// redacted returns the configuration without credentials. Every string field is redacted,
+// so URLs in fields added later, e.g. of servers, do not leak their user information either.
+func (c DriverConfig) redacted() DriverConfig {
+	v := reflect.ValueOf(&c).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if f := v.Field(i); f.Kind() == reflect.String {
+			f.SetString(credentialsRegexp.ReplaceAllString(f.String(), "://REDACTED@"))
+		}
+	}
+	return c
+}
+
+// debugState collects the state the debug endpoint shows
+func (d *Driver) debugState() *debugState {
+	notReady := d.readiness()
+	st := &debugState{
+		DriverName: d.config.DriverName,
+		Version:    DriverVersion,
+		NodeID:     d.config.NodeID,
+		Bootstrap: bootstrapStatus{
+			Ready:      notReady == nil,
+			Reconciled: atomic.LoadInt32(&d.reconciled) != 0,
+		},
+		Config:       d.config.redacted(),
+		Repositories: []MountReport{},
+		Errors:       d.lastErrors.list(),
+	}
+	if notReady != nil {
+		st.Bootstrap.NotReady = notReady.Error()
+	}
+	if unavailable := d.availability(); unavailable != nil {
+		st.Bootstrap.Unavailable = unavailable.Error()
+	}
+
+	a := &Admin{d: d}
+	mounts, err := a.Mounts()
+	if err == nil {
+		for _, m := range mounts {
+			if m.Kind == mountKindRepository || m.Kind == mountKindIsolated {
+				st.Repositories = append(st.Repositories, m)
+			}
+		}
+		st.Volumes, err = a.Volumes()
+	}
+	if err != nil {
+		st.StateError = err.Error()
+	}
+	return st
+}
+
+// serveDebug serves the debug state and the Go profiler on address. It never returns.
+func (d *Driver) serveDebug(address string) {
+	log := internal.GetLogger("debug")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/state", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(d.debugState()); err != nil {
+			log.Error().Err(err).Msg("cannot write debug state")
+		}
+	})
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	// no /debug/pprof/cmdline, the command line has the credentials redacted from the state
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	log.Info().Str("address", address).Msg("serving debug endpoint")
+	err := http.ListenAndServe(address, mux)
+	log.Error().Err(err).Msg("debug endpoint stopped")
+}
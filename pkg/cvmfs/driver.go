@@ -18,6 +18,9 @@ package cvmfs
 
 import (
 	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
 
 	"github.com/cernops/cvmfs-csi/internal"
 	"github.com/container-storage-interface/spec/lib/go/csi"
@@ -32,9 +35,35 @@ type Driver struct {
 	*csi.UnimplementedControllerServer
 	*csi.UnimplementedNodeServer
 
-	config                 DriverConfig
+	config DriverConfig
+	state  *stateStore
+	// mountMu is held for writing while unused repositories are unmounted
+	mountMu                sync.RWMutex
+	events                 *eventRecorder
+	profiles               map[string]*SiteProfile
+	publicKeys             map[string]publicKeys
 	controllerCapabilities []*csi.ControllerServiceCapability
 	VolumeCapabilities     []*csi.VolumeCapability
+
+	// isolatedMu serializes publishing and unpublishing protected volumes
+	isolatedMu sync.Mutex
+
+	// holder runs the cvmfs clients if the driver has a mount holder
+	holder *mountHolderClient
+	// clientUser is who the clients run as with fuse file descriptor passing, nil without
+	clientUser *clientUser
+
+	// notReady is set when the node cannot serve volumes, and explains why.
+	// It is guarded by readyMu, see readiness.
+	readyMu  sync.Mutex
+	notReady error
+	// unavailable is set when the node cannot set up the client, and is reported
+	// in its topology, see checkAvailability. It is guarded by readyMu too.
+	unavailable error
+	// reconciled is set once reconcile ran, it is read by the debug endpoint
+	reconciled int32
+	// lastErrors are the recent errors, for the debug endpoint
+	lastErrors *recentErrors
 }
 
 const DriverVersion = "1.0.1"
@@ -45,6 +74,44 @@ type DriverConfig struct {
 	Endpoint    string
 	Proxy       string
 	CacheFolder string
+	// CachePolicy decides which repositories share a cache directory below CacheFolder
+	CachePolicy CachePolicy
+	// CacheQuota is the default CVMFS_QUOTA_LIMIT of every cache directory, in MB
+	CacheQuota uint64
+	// CacheType selects the cache manager of the clients, see CacheType
+	CacheType CacheType
+	// AlienCacheDir is the alien cache, or the lower tier of a tiered cache
+	AlienCacheDir string
+	// AlienCacheReadOnly keeps the clients from writing to the lower tier of a tiered cache
+	AlienCacheReadOnly bool
+	// RAMCacheSize is the size of the RAM cache, or of the upper tier of a tiered cache, in MB
+	RAMCacheSize uint64
+	// ProfilesFile is a JSON file with the site profiles volumes can select
+	ProfilesFile string
+	// PublicKeysDir holds additional repository keys, a directory of .pub files per domain
+	PublicKeysDir string
+	// PreloadDir holds the preloaded caches volumes can be mounted from, empty to disable them
+	PreloadDir string
+	// MountHolder is the socket of the mount holder running the cvmfs clients,
+	// empty to run them in the node plugin
+	MountHolder string
+	// FuseFdPassing makes the mount holder mount the fuse filesystems itself, and pass
+	// them to clients running unprivileged as ClientUser, a user name or uid:gid
+	FuseFdPassing bool
+	ClientUser    string
+	// Automount runs autofs on /cvmfs, mounting repositories on access
+	Automount bool
+	// Site and ProxyGroup are optional topology segments reported for this node
+	Site       string
+	ProxyGroup string
+	// StateDir persists what is staged and published on this node across restarts
+	StateDir string
+	// KubeletDir is the root directory of the kubelet, as seen by the plugin
+	KubeletDir string
+	// KubeEvents records Kubernetes events for mount failures on the affected objects
+	KubeEvents bool
+	// DebugAddress serves the state of the driver and the Go profiler over HTTP, empty to disable
+	DebugAddress string
 }
 
 // NewDriver constructs a new Driver given a valid DriverConfig
@@ -61,18 +128,113 @@ func NewDriver(c DriverConfig) (*Driver, error) {
 		return nil, errors.New("Driver endpoint missing")
 	}
 
+	if c.StateDir == "" {
+		return nil, errors.New("State directory missing")
+	}
+
+	if err := validateCacheConfig(&c); err != nil {
+		return nil, fmt.Errorf("Invalid cache configuration: %w", err)
+	}
+
 	log := internal.GetLogger("NewDriver")
 	log.Info().Str("driver name", c.DriverName).Str("node ID", c.NodeID).Str("endpoint", c.Endpoint).Msg("new driver")
-	driver := &Driver{config: c}
-	driver.VolumeCapabilities = []*csi.VolumeCapability{mountVolumeCapability(csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY)}
+
+	state, err := newStateStore(c.StateDir)
+	if err != nil {
+		return nil, err
+	}
+
+	driver := &Driver{config: c, state: state, lastErrors: &recentErrors{}}
+	if c.ProfilesFile != "" {
+		if driver.profiles, err = loadSiteProfiles(c.ProfilesFile); err != nil {
+			return nil, fmt.Errorf("cannot load site profiles: %w", err)
+		}
+		log.Info().Int("profiles", len(driver.profiles)).Msg("site profiles loaded")
+	}
+	if c.PublicKeysDir != "" {
+		if driver.publicKeys, err = loadPublicKeys(c.PublicKeysDir); err != nil {
+			return nil, fmt.Errorf("cannot load public keys: %w", err)
+		}
+	}
+	if c.FuseFdPassing {
+		if c.Automount {
+			return nil, errors.New("fuse file descriptor passing cannot be combined with automount, autofs mounts repositories itself")
+		}
+		if c.MountHolder == "" {
+			return nil, errors.New("fuse file descriptor passing requires a mount holder, which opens /dev/fuse and mounts for the driver")
+		}
+		if driver.clientUser, err = lookupClientUser(c.ClientUser); err != nil {
+			return nil, fmt.Errorf("cannot resolve client user %s: %w", c.ClientUser, err)
+		}
+		if driver.clientUser.UID == 0 {
+			return nil, fmt.Errorf("client user %s is root, the mount holder does not run clients as root", c.ClientUser)
+		}
+		log.Info().Int("uid", driver.clientUser.UID).Int("gid", driver.clientUser.GID).Msg("clients run unprivileged")
+	}
+	if c.MountHolder != "" {
+		driver.holder = newMountHolderClient(c.MountHolder)
+		log.Info().Str("socket", c.MountHolder).Msg("using mount holder")
+	}
+	if c.FuseFdPassing {
+		// the plugin does not need to be privileged, the mount holder mounts everything for it
+		mountHelper = driver.holder
+	}
+	if c.KubeEvents {
+		if driver.events, err = newInClusterEventRecorder(c.DriverName, c.NodeID); err != nil {
+			return nil, fmt.Errorf("cannot set up event recording: %w", err)
+		}
+	}
+	driver.VolumeCapabilities = []*csi.VolumeCapability{
+		mountVolumeCapability(csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY),
+		mountVolumeCapability(csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY),
+	}
 	driver.controllerCapabilities = []*csi.ControllerServiceCapability{controllerCapability(csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME)}
 	return driver, nil
 }
 
+// setNotReady records why the node cannot serve volumes, nil once it can
+func (d *Driver) setNotReady(err error) {
+	d.readyMu.Lock()
+	defer d.readyMu.Unlock()
+	d.notReady = err
+}
+
+// readiness returns why the node cannot serve volumes, or nil if it can
+func (d *Driver) readiness() error {
+	d.readyMu.Lock()
+	defer d.readyMu.Unlock()
+	return d.notReady
+}
+
 // Run starts the driver and waits for it to stop.
 // If a driver stopped it signifies something went wrong
 func (d *Driver) Run() {
-	server := &nonBlockingGRPCServer{}
+	log := internal.GetLogger("Run")
+	go d.serveReadiness()
+
+	if err := d.checkMountPropagation(); err != nil {
+		log.Error().Err(err).Msg("mount propagation is misconfigured, refusing to serve volumes")
+		d.setNotReady(err)
+		d.lastErrors.add("checkMountPropagation", "", err)
+	}
+
+	if d.config.DebugAddress != "" {
+		go d.serveDebug(d.config.DebugAddress)
+	}
+
+	// the topology kubelet asks for when the plugin registers depends on it
+	d.checkAvailability()
+
+	if err := d.reconcile(); err != nil {
+		log.Error().Err(err).Msg("reconciliation of existing mounts failed")
+		d.lastErrors.add("reconcile", "", err)
+	}
+	atomic.StoreInt32(&d.reconciled, 1)
+
+	go d.runRefresher()
+	go d.runCacheCleaner()
+
+	server := &nonBlockingGRPCServer{onError: d.recordGRPCError}
 	server.Start(d.config.Endpoint, d, d, d)
 	server.Wait()
 }
@@ -84,6 +246,16 @@ func mountVolumeCapability(mode csi.VolumeCapability_AccessMode_Mode) *csi.Volum
 	}
 }
 
+// isReadOnlyAccessMode reports whether an access mode can be served by a read-only filesystem
+func isReadOnlyAccessMode(mode csi.VolumeCapability_AccessMode_Mode) bool {
+	switch mode {
+	case csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY,
+		csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY:
+		return true
+	}
+	return false
+}
+
 func controllerCapability(c csi.ControllerServiceCapability_RPC_Type) *csi.ControllerServiceCapability {
 	return &csi.ControllerServiceCapability{
 		Type: &csi.ControllerServiceCapability_Rpc{
@@ -0,0 +1,257 @@
+// Copyright CERN.
+//
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cvmfs
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cernops/cvmfs-csi/internal"
+	"google.golang.org/grpc/status"
+)
+
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// eventInterval limits how often the same event is recorded for an object,
+// as kubelet retries failed operations every few seconds
+const eventInterval = 5 * time.Minute
+
+// Volume context keys set by the external-provisioner (--extra-create-metadata)
+// and by kubelet (podInfoOnMount)
+const (
+	contextPVName       = "csi.storage.k8s.io/pv/name"
+	contextPVCName      = "csi.storage.k8s.io/pvc/name"
+	contextPVCNamespace = "csi.storage.k8s.io/pvc/namespace"
+	contextPodName      = "csi.storage.k8s.io/pod.name"
+	contextPodNamespace = "csi.storage.k8s.io/pod.namespace"
+	contextPodUID       = "csi.storage.k8s.io/pod.uid"
+)
+
+// objectReference points at the Kubernetes object an event is about
+type objectReference struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Namespace  string `json:"namespace,omitempty"`
+	Name       string `json:"name"`
+	UID        string `json:"uid,omitempty"`
+}
+
+// eventRecorder posts core/v1 Events to the API server, authenticating
+// with the service account of the plugin pod
+type eventRecorder struct {
+	component string
+	host      string
+	apiServer string
+	// tokenFile is read for every event, since projected tokens are rotated
+	tokenFile string
+	client    *http.Client
+
+	mu     sync.Mutex
+	recent map[string]time.Time
+}
+
+// newInClusterEventRecorder sets up an eventRecorder from the in-cluster environment
+func newInClusterEventRecorder(component, host string) (*eventRecorder, error) {
+	apiHost, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if apiHost == "" || port == "" {
+		return nil, errors.New("not running in a cluster, KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT are not set")
+	}
+
+	tokenFile := filepath.Join(serviceAccountDir, "token")
+	if _, err := readToken(tokenFile); err != nil {
+		return nil, err
+	}
+
+	ca, err := os.ReadFile(filepath.Join(serviceAccountDir, "ca.crt"))
+	if err != nil {
+		return nil, fmt.Errorf("cannot read service account CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, errors.New("no certificates in service account CA")
+	}
+
+	return &eventRecorder{
+		component: component,
+		host:      host,
+		apiServer: "https://" + net.JoinHostPort(apiHost, port),
+		tokenFile: tokenFile,
+		recent:    map[string]time.Time{},
+		client: &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+		},
+	}, nil
+}
+
+// readToken reads the service account token
+func readToken(tokenFile string) (string, error) {
+	token, err := os.ReadFile(tokenFile)
+	if err != nil {
+		return "", fmt.Errorf("cannot read service account token: %w", err)
+	}
+	return strings.TrimSpace(string(token)), nil
+}
+
+// Warning records a warning event. It does not block, failures are only logged.
+func (r *eventRecorder) Warning(obj objectReference, reason, message string) {
+	if !r.due(obj, reason) {
+		return
+	}
+	go func() {
+		if err := r.post(obj, "Warning", reason, message); err != nil {
+			log := internal.GetLogger("eventRecorder")
+			log.Error().Err(err).Str("kind", obj.Kind).Str("name", obj.Name).Str("reason", reason).Msg("cannot record event")
+		}
+	}()
+}
+
+// due reports whether an event has not been recorded recently for the object
+func (r *eventRecorder) due(obj objectReference, reason string) bool {
+	key := strings.Join([]string{obj.Kind, obj.Namespace, obj.Name, reason}, "/")
+	now := time.Now()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if last, ok := r.recent[key]; ok && now.Sub(last) < eventInterval {
+		return false
+	}
+	for k, t := range r.recent {
+		if now.Sub(t) >= eventInterval {
+			delete(r.recent, k)
+		}
+	}
+	r.recent[key] = now
+	return true
+}
+
+func (r *eventRecorder) post(obj objectReference, eventType, reason, message string) error {
+	// events about cluster scoped objects live in the default namespace
+	namespace := obj.Namespace
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	event := map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Event",
+		"metadata": map[string]interface{}{
+			"generateName": obj.Name + ".",
+			"namespace":    namespace,
+		},
+		"involvedObject":     obj,
+		"reason":             reason,
+		"message":            message,
+		"type":               eventType,
+		"count":              1,
+		"firstTimestamp":     now,
+		"lastTimestamp":      now,
+		"source":             map[string]string{"component": r.component, "host": r.host},
+		"reportingComponent": r.component,
+		"reportingInstance":  r.host,
+	}
+
+	b, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	token, err := readToken(r.tokenFile)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/api/v1/namespaces/%s/events", r.apiServer, namespace), bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("API server answered %s", resp.Status)
+	}
+	return nil
+}
+
+// volumeObjects returns the PersistentVolume and PersistentVolumeClaim a volume belongs to,
+// as far as they are known from the volume context or the kubelet volume data
+func volumeObjects(volumeContext map[string]string, stagingTargetPath string) []objectReference {
+	var objs []objectReference
+
+	pv := volumeContext[contextPVName]
+	if pv == "" && stagingTargetPath != "" {
+		if data, err := readKubeletVolumeData(stagingTargetPath); err == nil {
+			pv = data.SpecVolID
+		}
+	}
+	if pv != "" {
+		objs = append(objs, objectReference{APIVersion: "v1", Kind: "PersistentVolume", Name: pv})
+	}
+
+	if pvc, ns := volumeContext[contextPVCName], volumeContext[contextPVCNamespace]; pvc != "" && ns != "" {
+		objs = append(objs, objectReference{APIVersion: "v1", Kind: "PersistentVolumeClaim", Namespace: ns, Name: pvc})
+	}
+	return objs
+}
+
+// podObject returns the pod a volume is published to, if kubelet passed pod information
+func podObject(volumeContext map[string]string) (objectReference, bool) {
+	name, ns := volumeContext[contextPodName], volumeContext[contextPodNamespace]
+	if name == "" || ns == "" {
+		return objectReference{}, false
+	}
+	return objectReference{APIVersion: "v1", Kind: "Pod", Namespace: ns, Name: name, UID: volumeContext[contextPodUID]}, true
+}
+
+// recordStageFailure emits an event about a failed NodeStageVolume on the PV and PVC
+func (d *Driver) recordStageFailure(volumeContext map[string]string, stagingTargetPath string, err error) {
+	if d.events == nil {
+		return
+	}
+	reason, message := mountFailureKind(err).Reason(), status.Convert(err).Message()
+	for _, obj := range volumeObjects(volumeContext, stagingTargetPath) {
+		d.events.Warning(obj, reason, message)
+	}
+}
+
+// recordPublishFailure emits an event about a failed NodePublishVolume on the pod
+func (d *Driver) recordPublishFailure(volumeContext map[string]string, err error) {
+	if d.events == nil {
+		return
+	}
+	if pod, ok := podObject(volumeContext); ok {
+		d.events.Warning(pod, mountFailureKind(err).Reason(), status.Convert(err).Message())
+	}
+}
@@ -18,6 +18,7 @@ package cvmfs
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"os/exec"
 	"time"
@@ -25,11 +26,26 @@ import (
 	"github.com/cernops/cvmfs-csi/internal"
 )
 
+// errCommandTimeout is returned when a command did not finish in time
+var errCommandTimeout = errors.New("command timed out")
+
 func execCommand(program string, args ...string) ([]byte, error) {
-	log := internal.GetLogger("execCommand").With().Str("program", program).Strs("args", args).Logger()
-	log.Info().Msg("executing command")
+	return execCommandEnv(nil, program, args...)
+}
 
+// execCommandEnv runs a command like execCommand, with env as its environment
+// instead of the one of the driver if env is not nil
+func execCommandEnv(env []string, program string, args ...string) ([]byte, error) {
 	cmd := exec.Command(program, args[:]...)
+	cmd.Env = env
+	return runCommand(cmd)
+}
+
+// runCommand runs a prepared command like execCommand
+func runCommand(cmd *exec.Cmd) ([]byte, error) {
+	log := internal.GetLogger("execCommand").With().Str("program", cmd.Path).Strs("args", cmd.Args[1:]).Logger()
+	log.Info().Msg("executing command")
+
 	var buf bytes.Buffer
 	cmd.Stdout = &buf
 	cmd.Stderr = &buf
@@ -45,7 +61,7 @@ func execCommand(program string, args ...string) ([]byte, error) {
 	select {
 	case <-timeout:
 		cmd.Process.Kill()
-		err = fmt.Errorf("command timed out")
+		err = errCommandTimeout
 	case err = <-done:
 		if err == nil && cmd.ProcessState.ExitCode() > 0 {
 			err = fmt.Errorf("non-zero exit code: %d", cmd.ProcessState.ExitCode())
@@ -0,0 +1,153 @@
+// Copyright CERN.
+//
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cvmfs
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/cernops/cvmfs-csi/internal"
+)
+
+// With fuse file descriptor passing the mount holder opens /dev/fuse and mounts the fuse
+// filesystem itself, and the clients serving the mounts run unprivileged, as clientUser.
+// The driver never opens /dev/fuse, it only asks the mount holder for mounts.
+
+// clientUser is who the clients run as with fuse file descriptor passing
+type clientUser struct {
+	UID int `json:"uid"`
+	GID int `json:"gid"`
+}
+
+// lookupClientUser resolves a user name, or a uid:gid pair
+func lookupClientUser(name string) (*clientUser, error) {
+	if i := strings.Index(name, ":"); i >= 0 {
+		uid, err := strconv.Atoi(name[:i])
+		if err != nil {
+			return nil, fmt.Errorf("invalid uid in %s", name)
+		}
+		gid, err := strconv.Atoi(name[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid gid in %s", name)
+		}
+		return &clientUser{UID: uid, GID: gid}, nil
+	}
+
+	u, err := user.Lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return nil, err
+	}
+	gid, err := strconv.Atoi(u.Gid)
+	if err != nil {
+		return nil, err
+	}
+	return &clientUser{UID: uid, GID: gid}, nil
+}
+
+// runUnprivilegedClient mounts repository r on to, and hands the mount to a client running as user.
+// It runs in the mount holder.
+func runUnprivilegedClient(r Repository, to string, config []string, env []string, user *clientUser) error {
+	log := internal.GetLogger("runUnprivilegedClient").With().Str("to", to).Str("repository", string(r)).
+		Int("uid", user.UID).Logger()
+
+	fuse, err := mountFuse(to, user)
+	if err != nil {
+		log.Error().Err(err).Msg("fuse mount failed")
+		return newMountError(r, "", err)
+	}
+	// the client keeps its own copy of the file descriptor
+	defer fuse.Close()
+
+	cmd := clientCommand(user, fuse, "-o", "libfuse=3,config="+strings.Join(config, ":"), string(r), "/dev/fd/3")
+	cmd.Env = append(os.Environ(), env...)
+	if out, err := runCommand(cmd); err != nil {
+		log.Error().Err(err).Bytes("output", out).Msg("client failed to start")
+		if derr := detachFuse(to); derr != nil {
+			log.Error().Err(derr).Msg("cannot remove fuse mount")
+		}
+		return newMountError(r, string(out), err)
+	}
+
+	log.Info().Msg("mounted")
+	return nil
+}
+
+// clientConfigChain returns the configuration files a client of repository r reads, in the order
+// mount.cvmfs reads them, except for the configuration the driver generates for r, which
+// is replaced by last
+func clientConfigChain(r Repository, last string) []string {
+	return clientConfigChainIn("/etc/cvmfs", filepath.Join(CVMFSConfigRepo.getMountPath(), "etc/cvmfs"), r, last)
+}
+
+// clientConfigChainIn returns the configuration chain of r with the local configuration in etc,
+// and the one of the config repository in configRepo. Like mount.cvmfs, the .conf files of the
+// config repository come after the local ones, and the .local files after both. default.conf
+// of the config repository comes after default.d, which is where the config repository is set.
+func clientConfigChainIn(etc, configRepo string, r Repository, last string) []string {
+	defaults, _ := filepath.Glob(filepath.Join(etc, "default.d", "*.conf"))
+
+	var candidates []string
+	candidates = append(candidates, filepath.Join(etc, "default.conf"))
+	candidates = append(candidates, defaults...)
+	candidates = append(candidates,
+		filepath.Join(configRepo, "default.conf"),
+		filepath.Join(etc, "default.local"),
+	)
+	if domain := r.domain(); domain != "" {
+		candidates = append(candidates,
+			filepath.Join(etc, "domain.d", domain+".conf"),
+			filepath.Join(configRepo, "domain.d", domain+".conf"),
+			filepath.Join(etc, "domain.d", domain+".local"),
+		)
+	}
+	candidates = append(candidates,
+		filepath.Join(etc, "config.d", string(r)+".conf"),
+		filepath.Join(configRepo, "config.d", string(r)+".conf"),
+		last,
+	)
+
+	var chain []string
+	for _, c := range candidates {
+		if _, err := os.Stat(c); err == nil {
+			chain = append(chain, c)
+		}
+	}
+	return chain
+}
+
+// mountUnprivileged mounts a repository on its mount path with a client running as the client user
+func (d *Driver) mountUnprivileged(r Repository) error {
+	cache := d.repositoryCacheBase(r)
+	if err := mkdir(cache); err != nil {
+		return fmt.Errorf("cannot create cache folder: %w", err)
+	}
+	if err := d.clientUser.chownTree(cache); err != nil {
+		return fmt.Errorf("cannot hand cache to client user: %w", err)
+	}
+
+	// NewDriver makes sure there is a mount holder to mount for the client user
+	config := clientConfigChain(r, r.getConfigPath())
+	return d.holder.MountClient(r, r.getMountPath(), config, nil, d.clientUser)
+}
@@ -0,0 +1,75 @@
+// Copyright CERN.
+//
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cvmfs
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+)
+
+// mountFuse opens /dev/fuse and mounts a fuse filesystem served through it on target,
+// for a client running as user. The client is handed the returned file, and finds
+// the mount as /dev/fd/N, which needs a client built with libfuse 3.3 or newer.
+func mountFuse(target string, user *clientUser) (*os.File, error) {
+	f, err := os.OpenFile("/dev/fuse", os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	data := fmt.Sprintf("fd=%d,rootmode=40000,user_id=%d,group_id=%d,allow_other", f.Fd(), user.UID, user.GID)
+	flags := uintptr(syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_RDONLY)
+	if err := syscall.Mount(cvmfsMountSource, target, "fuse", flags, data); err != nil {
+		f.Close()
+		return nil, &os.PathError{Op: "mount", Path: target, Err: err}
+	}
+	return f, nil
+}
+
+// detachFuse removes a fuse mount whose client did not start
+func detachFuse(target string) error {
+	return syscall.Unmount(target, syscall.MNT_DETACH)
+}
+
+// clientCommand returns the command running a client as user, with fuse as its file descriptor 3
+func clientCommand(user *clientUser, fuse *os.File, args ...string) *exec.Cmd {
+	cmd := exec.Command("/usr/bin/cvmfs2", args...)
+	cmd.ExtraFiles = []*os.File{fuse}
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Credential: &syscall.Credential{Uid: uint32(user.UID), Gid: uint32(user.GID)},
+	}
+	return cmd
+}
+
+// chownTree hands a directory tree to user, unless its root already belongs to it
+func (user *clientUser) chownTree(dir string) error {
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok && int(st.Uid) == user.UID && int(st.Gid) == user.GID {
+		return nil
+	}
+	return filepath.Walk(dir, func(p string, _ os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		return os.Lchown(p, user.UID, user.GID)
+	})
+}
@@ -0,0 +1,44 @@
+// Copyright CERN.
+//
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+//go:build !linux
+// +build !linux
+
+package cvmfs
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+)
+
+var errFuseFdPassingUnsupported = errors.New("fuse file descriptor passing is only supported on Linux")
+
+func mountFuse(target string, user *clientUser) (*os.File, error) {
+	return nil, errFuseFdPassingUnsupported
+}
+
+func detachFuse(target string) error {
+	return errFuseFdPassingUnsupported
+}
+
+func clientCommand(user *clientUser, fuse *os.File, args ...string) *exec.Cmd {
+	return exec.Command("/usr/bin/cvmfs2", args...)
+}
+
+func (user *clientUser) chownTree(dir string) error {
+	return errFuseFdPassingUnsupported
+}
@@ -0,0 +1,77 @@
+// Copyright CERN.
+//
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cvmfs
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestClientConfigChain(t *testing.T) {
+	etc := t.TempDir()
+	configRepo := t.TempDir()
+	last := filepath.Join(t.TempDir(), "client.conf")
+
+	files := []string{
+		// mount.cvmfs order: the config repository is set in default.d,
+		// so its default.conf is only read after default.d
+		filepath.Join(etc, "default.conf"),
+		filepath.Join(etc, "default.d", "50-cern.conf"),
+		filepath.Join(etc, "default.d", "60-site.conf"),
+		filepath.Join(configRepo, "default.conf"),
+		filepath.Join(etc, "default.local"),
+		filepath.Join(etc, "domain.d", "cern.ch.conf"),
+		filepath.Join(configRepo, "domain.d", "cern.ch.conf"),
+		filepath.Join(etc, "domain.d", "cern.ch.local"),
+		filepath.Join(etc, "config.d", "atlas.cern.ch.conf"),
+		filepath.Join(configRepo, "config.d", "atlas.cern.ch.conf"),
+		last,
+	}
+	// files of other domains and repositories, and the generated configuration
+	// of the shared mount, are not read
+	others := []string{
+		filepath.Join(etc, "default.d", "README"),
+		filepath.Join(etc, "domain.d", "egi.eu.conf"),
+		filepath.Join(configRepo, "config.d", "cms.cern.ch.conf"),
+		filepath.Join(etc, "config.d", "atlas.cern.ch.local"),
+	}
+	for _, f := range append(append([]string{}, files...), others...) {
+		if err := os.MkdirAll(filepath.Dir(f), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(f, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got := clientConfigChainIn(etc, configRepo, "atlas.cern.ch", last)
+	if !reflect.DeepEqual(got, files) {
+		t.Errorf("clientConfigChainIn() =\n%v\nwant\n%v", got, files)
+	}
+
+	// missing files are left out
+	if err := os.Remove(filepath.Join(configRepo, "domain.d", "cern.ch.conf")); err != nil {
+		t.Fatal(err)
+	}
+	want := append(append([]string{}, files[:6]...), files[7:]...)
+	got = clientConfigChainIn(etc, configRepo, "atlas.cern.ch", last)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("clientConfigChainIn() without config repository domain =\n%v\nwant\n%v", got, want)
+	}
+}
@@ -10,6 +10,7 @@ import (
 
 	"github.com/cernops/cvmfs-csi/internal"
 	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/golang/protobuf/proto"
 	"github.com/kubernetes-csi/csi-lib-utils/protosanitizer"
 	"github.com/rs/zerolog"
 	"google.golang.org/grpc"
@@ -21,6 +22,8 @@ type nonBlockingGRPCServer struct {
 	wg      sync.WaitGroup
 	server  *grpc.Server
 	cleanup func()
+	// onError is called for every failed call, if set
+	onError func(method string, req interface{}, err error)
 }
 
 func (s *nonBlockingGRPCServer) Start(endpoint string, ids csi.IdentityServer, cs csi.ControllerServer, ns csi.NodeServer) {
@@ -50,7 +53,7 @@ func (s *nonBlockingGRPCServer) serve(ep string, ids csi.IdentityServer, cs csi.
 	}
 
 	opts := []grpc.ServerOption{
-		grpc.UnaryInterceptor(logGRPC),
+		grpc.ChainUnaryInterceptor(logGRPC, s.recordError),
 	}
 	server := grpc.NewServer(opts...)
 	s.server = server
@@ -79,7 +82,7 @@ func logGRPC(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, h
 	}
 
 	log.WithLevel(l).Msg("GRPC request")
-	log.WithLevel(l2).Msg(protosanitizer.StripSecrets(req).String())
+	log.WithLevel(l2).Msg(sanitizedRequest(req))
 
 	resp, err := handler(log.WithContext(ctx), req)
 
@@ -90,6 +93,32 @@ func logGRPC(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, h
 	return resp, err
 }
 
+// sanitizedRequest formats a request for logging, without its secrets. Service account
+// tokens come in the volume context rather than in fields marked as secret, so they
+// are stripped separately.
+func sanitizedRequest(req interface{}) string {
+	type volumeContextRequest interface {
+		proto.Message
+		GetVolumeContext() map[string]string
+	}
+	if r, ok := req.(volumeContextRequest); ok {
+		if _, ok := r.GetVolumeContext()[contextServiceAccountTokens]; ok {
+			c := proto.Clone(r).(volumeContextRequest)
+			c.GetVolumeContext()[contextServiceAccountTokens] = "***stripped***"
+			req = c
+		}
+	}
+	return protosanitizer.StripSecrets(req).String()
+}
+
+func (s *nonBlockingGRPCServer) recordError(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	resp, err := handler(ctx, req)
+	if err != nil && s.onError != nil {
+		s.onError(info.FullMethod, req, err)
+	}
+	return resp, err
+}
+
 func parseEndpoint(ep string) (string, string, error) {
 	if strings.HasPrefix(strings.ToLower(ep), "unix://") || strings.HasPrefix(strings.ToLower(ep), "tcp://") {
 		s := strings.SplitN(ep, "://", 2)
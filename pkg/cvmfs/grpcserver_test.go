@@ -0,0 +1,57 @@
+// Copyright CERN.
+//
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cvmfs
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+)
+
+func TestSanitizedRequest(t *testing.T) {
+	const token = "eyJhbGciOiJSUzI1NiJ9.secret-token"
+	tokens := `{"cvmfs":{"token":"` + token + `","expirationTimestamp":"2021-06-01T00:00:00Z"}}`
+	req := &csi.NodePublishVolumeRequest{
+		VolumeId:   "vol-1",
+		TargetPath: "/var/lib/kubelet/pods/uid/volumes/kubernetes.io~csi/vol-1/mount",
+		VolumeContext: map[string]string{
+			"repository":                "atlas.cern.ch",
+			contextServiceAccountTokens: tokens,
+		},
+		Secrets: map[string]string{"password": "node-publish-secret"},
+	}
+
+	logged := sanitizedRequest(req)
+	for _, secret := range []string{token, "node-publish-secret"} {
+		if strings.Contains(logged, secret) {
+			t.Errorf("logged request %q contains %q", logged, secret)
+		}
+	}
+	if !strings.Contains(logged, "atlas.cern.ch") {
+		t.Errorf("logged request %q lacks the rest of the volume context", logged)
+	}
+	if req.GetVolumeContext()[contextServiceAccountTokens] != tokens {
+		t.Error("sanitizedRequest() modified the request")
+	}
+
+	// requests without tokens are logged as they are
+	stage := &csi.NodeStageVolumeRequest{VolumeId: "vol-1", VolumeContext: map[string]string{"repository": "atlas.cern.ch"}}
+	if logged := sanitizedRequest(stage); !strings.Contains(logged, "atlas.cern.ch") {
+		t.Errorf("logged request %q lacks the volume context", logged)
+	}
+}
@@ -0,0 +1,417 @@
+// Copyright CERN.
+//
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cvmfs
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+
+	_ "embed"
+
+	"github.com/cernops/cvmfs-csi/internal"
+	"github.com/rs/zerolog"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+//go:embed isolatedconf.go.tpl
+var isolatedConfTemplateStr string
+var isolatedConfTemplate = template.Must(template.New("client.conf").Parse(isolatedConfTemplateStr))
+
+// Volumes that must not share the mount of their repository get a client of their own,
+// with its own configuration, cache and mount point in a directory below StateDir
+const isolatedVolumesDir = "isolated"
+
+// credentialsDir is where in its directory an isolated volume keeps its credentials
+const credentialsDir = "credentials"
+
+// isolatedCacheQuota is the cache quota in MB of an isolated client, unless the volume sets one
+const isolatedCacheQuota = 1000
+
+// credentialEnv maps the node-stage secret entries holding client credentials
+// to the environment variables the authz helpers of cvmfs find them with
+var credentialEnv = map[string]string{
+	"x509proxy": "X509_USER_PROXY",
+	"token":     "BEARER_TOKEN_FILE",
+}
+
+// errCredentialsGone is returned when the credentials of an isolated volume did not survive
+// a reboot of the node, which took their tmpfs with it
+var errCredentialsGone = errors.New("credentials were lost with their tmpfs, the volume has to be staged again")
+
+// credentials maps credential entries of node-stage secrets to their content
+type credentials map[string][]byte
+
+// credentialsFromSecrets returns the client credentials among node-stage secrets
+func credentialsFromSecrets(secrets map[string]string) (credentials, error) {
+	creds := credentials{}
+	for name := range credentialEnv {
+		v, ok := secrets[name]
+		if !ok {
+			continue
+		}
+		if v == "" {
+			return nil, fmt.Errorf("credential %s is empty", name)
+		}
+		creds[name] = []byte(v)
+	}
+	return creds, nil
+}
+
+// names returns the sorted entry names of the credentials
+func (c credentials) names() []string {
+	names := make([]string, 0, len(c))
+	for name := range c {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// isolatedVolume is the directory of the client of an isolated volume
+type isolatedVolume struct {
+	dir string
+}
+
+func (d *Driver) isolatedVolume(volID string) isolatedVolume {
+	return isolatedVolume{dir: filepath.Join(d.config.StateDir, isolatedVolumesDir, url.PathEscape(volID))}
+}
+
+func (v isolatedVolume) mountPath() string       { return filepath.Join(v.dir, "mnt") }
+func (v isolatedVolume) cachePath() string       { return filepath.Join(v.dir, "cache") }
+func (v isolatedVolume) workspacePath() string   { return filepath.Join(v.dir, "workspace") }
+func (v isolatedVolume) credentialsPath() string { return filepath.Join(v.dir, credentialsDir) }
+func (v isolatedVolume) keysPath() string        { return filepath.Join(v.dir, "keys") }
+func (v isolatedVolume) configPath() string      { return filepath.Join(v.dir, "client.conf") }
+
+// talk returns a client for the control socket of the isolated client
+func (v isolatedVolume) talk(r Repository) *talkClient {
+	return &talkClient{socket: filepath.Join(v.workspacePath(), "cvmfs_io."+string(r))}
+}
+
+// writeCredentials stores credentials on a tmpfs, so they never reach a disk, readable
+// by root only, or by user when the client runs unprivileged. Credentials are
+// replaced, e.g. when tokens were renewed.
+func (v isolatedVolume) writeCredentials(creds credentials, user *clientUser) error {
+	if len(creds) == 0 {
+		return nil
+	}
+	p := v.credentialsPath()
+	if err := os.MkdirAll(p, 0700); err != nil {
+		return err
+	}
+
+	st, err := probeMount(p)
+	if err != nil {
+		return err
+	}
+	if st != mountHealthy {
+		if st == mountBroken {
+			if err := lazyUnmount(p); err != nil {
+				return err
+			}
+		}
+		if err := mountCredentialsTmpfs(p); err != nil {
+			return err
+		}
+	}
+	if user != nil {
+		if err := os.Lchown(p, user.UID, user.GID); err != nil {
+			return fmt.Errorf("cannot hand credentials to client user: %w", err)
+		}
+	}
+
+	for name, b := range creds {
+		tmp := filepath.Join(p, "."+name+".tmp")
+		if err := os.WriteFile(tmp, b, 0600); err != nil {
+			return fmt.Errorf("cannot write credential %s: %w", name, err)
+		}
+		// renewed credentials replace the file, it has to be handed over again
+		if user != nil {
+			if err := os.Lchown(tmp, user.UID, user.GID); err != nil {
+				return fmt.Errorf("cannot hand credential %s to client user: %w", name, err)
+			}
+		}
+		if err := os.Rename(tmp, filepath.Join(p, name)); err != nil {
+			return fmt.Errorf("cannot write credential %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// mountCredentialsTmpfs mounts the tmpfs the credentials of an isolated volume are kept on
+func mountCredentialsTmpfs(p string) error {
+	if mountHelper != nil {
+		return mountHelper.MountTmpfs(p)
+	}
+	if out, err := execCommand("/usr/bin/mount", "-t", "tmpfs", "-o", "size=1m,mode=0700", "tmpfs", p); err != nil {
+		return fmt.Errorf("cannot mount tmpfs for credentials: %w: %s", err, out)
+	}
+	return nil
+}
+
+// readCredentials reads back the credentials with the given names, which only
+// works as long as their tmpfs is still mounted
+func (v isolatedVolume) readCredentials(names []string) (credentials, error) {
+	creds := credentials{}
+	if len(names) == 0 {
+		return creds, nil
+	}
+	if st, err := probeMount(v.credentialsPath()); err != nil {
+		return nil, err
+	} else if st != mountHealthy {
+		return nil, errCredentialsGone
+	}
+	for _, name := range names {
+		b, err := os.ReadFile(filepath.Join(v.credentialsPath(), name))
+		if os.IsNotExist(err) {
+			return nil, errCredentialsGone
+		} else if err != nil {
+			return nil, err
+		}
+		creds[name] = b
+	}
+	return creds, nil
+}
+
+// environment returns the additions to the environment of the client, pointing the
+// authz helpers at the credentials
+func (v isolatedVolume) environment(creds credentials) []string {
+	var env []string
+	for _, name := range creds.names() {
+		env = append(env, credentialEnv[name]+"="+filepath.Join(v.credentialsPath(), name))
+	}
+	return env
+}
+
+// isolatedVolumeSource mounts the repository of a volume with a client of its own,
+// which uses the credentials and public keys of the volume, and returns the path to
+// bind-mount into the staging path. The client shares no cache with other clients.
+func (d *Driver) isolatedVolumeSource(log zerolog.Logger, volID string, opts *VolumeOptions, creds credentials, keys publicKeys) (string, error) {
+	switch {
+	case opts.Automount:
+		return "", status.Error(codes.InvalidArgument, "automount volumes cannot be isolated")
+	case opts.Preload != "":
+		return "", status.Error(codes.InvalidArgument, "preloaded volumes cannot be isolated")
+	case opts.CacheGroup != "":
+		return "", status.Error(codes.InvalidArgument, "isolated volumes have a cache of their own and cannot use a cacheGroup")
+	}
+
+	r := opts.Repository
+	v := d.isolatedVolume(volID)
+	log = log.With().Str("isolated", v.dir).Logger()
+
+	for _, p := range []string{v.dir, v.cachePath(), v.workspacePath()} {
+		if err := os.MkdirAll(p, 0700); err != nil {
+			return "", status.Error(codes.Internal, fmt.Sprintf("cannot create folder %s: %v", p, err))
+		}
+	}
+	if err := v.writeCredentials(creds, d.clientUser); err != nil {
+		return "", status.Error(codes.Internal, fmt.Sprintf("cannot store credentials: %v", err))
+	}
+
+	st, err := probeMount(v.mountPath())
+	if err != nil {
+		return "", status.Error(codes.Internal, fmt.Sprintf("cannot probe if folder is already mounted %s: %v", v.mountPath(), err))
+	}
+	switch st {
+	case mountHealthy:
+		log.Debug().Msg("isolated volume already mounted")
+		return d.subdirectorySource(v.mountPath(), opts)
+	case mountBroken:
+		log.Warn().Msg("isolated mount is broken, remounting")
+		if err := lazyUnmount(v.mountPath()); err != nil {
+			return "", status.Error(codes.Internal, fmt.Sprintf("cannot unmount broken mount %s: %v", v.mountPath(), err))
+		}
+	}
+
+	profile, err := d.siteProfile(opts)
+	if err != nil {
+		return "", err
+	}
+	if err := d.writeIsolatedKeys(v, r, keys); err != nil {
+		return "", status.Error(codes.Internal, fmt.Sprintf("cannot install public keys: %v", err))
+	}
+	if err := d.writeIsolatedConfig(volID, v, opts, profile, len(keys) != 0); err != nil {
+		return "", status.Error(codes.Internal, fmt.Sprintf("cannot configure repository: %v", err))
+	}
+
+	log.Debug().Msg("mounting isolated volume")
+	if err := d.mountIsolated(r, v, creds); err != nil {
+		return "", mountErrorStatus(err, "cannot mount volume: %v", err)
+	}
+	log.Info().Msg("isolated volume mounted")
+
+	if rev, err := v.talk(r).Revision(); err != nil {
+		log.Debug().Err(err).Msg("cannot query repository revision")
+	} else {
+		log.Debug().Uint64("revision", rev).Msg("repository revision")
+	}
+
+	return d.subdirectorySource(v.mountPath(), opts)
+}
+
+// writeIsolatedKeys gives the isolated client of a volume that brought public keys
+// a keys directory of its own, with the keys of the volume and those of its domain
+func (d *Driver) writeIsolatedKeys(v isolatedVolume, r Repository, keys publicKeys) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	domain := r.domain()
+	if domain == "" {
+		return fmt.Errorf("repository %s has no domain to install public keys for", r)
+	}
+
+	all := configRepoKeys(domain)
+	for name, b := range d.publicKeys[domain] {
+		all[name] = b
+	}
+	for name, b := range keys {
+		all[name] = b
+	}
+
+	if err := os.RemoveAll(v.keysPath()); err != nil {
+		return err
+	}
+	if err := mkdir(v.keysPath()); err != nil {
+		return err
+	}
+	for name, b := range all {
+		if err := os.WriteFile(filepath.Join(v.keysPath(), name), b, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeIsolatedConfig generates the configuration of the isolated client of a volume
+func (d *Driver) writeIsolatedConfig(volID string, v isolatedVolume, opts *VolumeOptions, profile *SiteProfile, ownKeys bool) error {
+	repositoryConf, err := d.renderRepositoryConfig(opts, profile, "", "")
+	if err != nil {
+		return err
+	}
+
+	quota := opts.CacheQuota
+	if quota == 0 {
+		quota = isolatedCacheQuota
+	}
+
+	var tpl bytes.Buffer
+	data := struct {
+		DriverName       string
+		VolumeID         string
+		CacheBase        string
+		CacheQuota       uint64
+		Workspace        string
+		KeysDir          string
+		RepositoryConfig string
+	}{d.config.DriverName, volID, v.cachePath(), quota, v.workspacePath(), "", string(repositoryConf)}
+	if ownKeys {
+		data.KeysDir = v.keysPath()
+	}
+	if err := isolatedConfTemplate.Execute(&tpl, data); err != nil {
+		return fmt.Errorf("cannot generate isolated client config: %w", err)
+	}
+	return os.WriteFile(v.configPath(), tpl.Bytes(), 0600)
+}
+
+// mountIsolated runs a client of repository r for an isolated volume.
+// Unlike mount.cvmfs this does not use the configuration of the shared mount of r.
+func (d *Driver) mountIsolated(r Repository, v isolatedVolume, creds credentials) error {
+	config := clientConfigChain(r, v.configPath())
+	if d.clientUser != nil {
+		if err := d.handIsolatedVolume(v); err != nil {
+			return fmt.Errorf("cannot hand isolated volume to client user: %w", err)
+		}
+	}
+	if d.holder != nil {
+		return d.holder.MountClient(r, v.mountPath(), config, v.environment(creds), d.clientUser)
+	}
+	return runClient(r, v.mountPath(), config, v.environment(creds), d.clientUser)
+}
+
+// handIsolatedVolume gives the client user access to what the isolated client of a volume needs
+func (d *Driver) handIsolatedVolume(v isolatedVolume) error {
+	for _, p := range []string{v.cachePath(), v.workspacePath()} {
+		if err := d.clientUser.chownTree(p); err != nil {
+			return err
+		}
+	}
+
+	paths := []string{v.dir, v.credentialsPath(), v.configPath()}
+	creds, _ := filepath.Glob(filepath.Join(v.credentialsPath(), "*"))
+	for _, p := range append(paths, creds...) {
+		if err := os.Lchown(p, d.clientUser.UID, d.clientUser.GID); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// runClient mounts repository r on to with the given configuration files, adding env
+// to the environment of the client. With a user, the client runs as that user.
+func runClient(r Repository, to string, config []string, env []string, user *clientUser) error {
+	log := internal.GetLogger("runClient").With().Str("to", to).Str("repository", string(r)).Logger()
+
+	if err := os.MkdirAll(to, 0755); err != nil {
+		return fmt.Errorf("cannot create target folder: %w", err)
+	}
+	if user != nil {
+		return runUnprivilegedClient(r, to, config, env, user)
+	}
+
+	options := "fsname=" + cvmfsMountSource + ",allow_other,grab_mountpoint,config=" + strings.Join(config, ":")
+	if out, err := execCommandEnv(append(os.Environ(), env...), "/usr/bin/cvmfs2", "-o", options, string(r), to); err != nil {
+		log.Error().Err(err).Bytes("output", out).Msg("mount failed")
+		return newMountError(r, string(out), err)
+	}
+
+	log.Info().Msg("mounted")
+	return nil
+}
+
+// removeIsolatedVolume unmounts the client of an isolated volume, wipes its
+// credentials and removes its directory. Volumes that are not isolated are left alone.
+func (d *Driver) removeIsolatedVolume(volID string) error {
+	v := d.isolatedVolume(volID)
+	if _, err := os.Lstat(v.dir); os.IsNotExist(err) {
+		return nil
+	}
+
+	if err := unmountAndRemove(v.mountPath()); err != nil {
+		return fmt.Errorf("cannot unmount isolated client: %w", err)
+	}
+	// a tmpfs that has to be detached lazily lives on while it is in use,
+	// the credentials must not live on with it
+	creds, _ := filepath.Glob(filepath.Join(v.credentialsPath(), "*"))
+	for _, p := range creds {
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("cannot remove credential %s: %w", p, err)
+		}
+	}
+	if err := unmountAndRemove(v.credentialsPath()); err != nil {
+		return fmt.Errorf("cannot remove credentials: %w", err)
+	}
+	return os.RemoveAll(v.dir)
+}
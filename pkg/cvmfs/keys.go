@@ -0,0 +1,222 @@
+// Copyright CERN.
+//
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cvmfs
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/cernops/cvmfs-csi/internal"
+)
+
+const (
+	CVMFSKeysDir   = "/etc/cvmfs/keys"
+	CVMFSDomainDir = "/etc/cvmfs/domain.d"
+)
+
+// publicKeySuffix marks the entries of node-stage secrets that are repository keys
+const publicKeySuffix = ".pub"
+
+var (
+	keyNameRegexp = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*\.pub$`)
+	domainRegexp  = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9.-]*$`)
+)
+
+// publicKeys maps key file names to PEM encoded keys
+type publicKeys map[string][]byte
+
+// domain returns the domain a repository belongs to, e.g. cern.ch for sft.cern.ch
+func (r *Repository) domain() string {
+	if i := strings.Index(string(*r), "."); i >= 0 {
+		return string(*r)[i+1:]
+	}
+	return ""
+}
+
+// validatePublicKey checks that a key is a PEM encoded RSA public key, as cvmfs expects
+func validatePublicKey(b []byte) error {
+	block, rest := pem.Decode(b)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return fmt.Errorf("not a PEM encoded public key")
+	}
+	if len(bytes.TrimSpace(rest)) != 0 {
+		return fmt.Errorf("unexpected data after the public key")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return err
+	}
+	if _, ok := key.(*rsa.PublicKey); !ok {
+		return fmt.Errorf("not an RSA public key")
+	}
+	return nil
+}
+
+// publicKeysFromSecrets returns the keys among node-stage secrets, i.e. all entries ending with .pub
+func publicKeysFromSecrets(secrets map[string]string) (publicKeys, error) {
+	keys := publicKeys{}
+	for name, v := range secrets {
+		if !strings.HasSuffix(name, publicKeySuffix) {
+			continue
+		}
+		if !keyNameRegexp.MatchString(name) {
+			return nil, fmt.Errorf("invalid public key name '%s'", name)
+		}
+		if err := validatePublicKey([]byte(v)); err != nil {
+			return nil, fmt.Errorf("invalid public key %s: %w", name, err)
+		}
+		keys[name] = []byte(v)
+	}
+	return keys, nil
+}
+
+// loadPublicKeys reads the keys configured for the driver from a directory
+// holding a subdirectory with .pub files per domain
+func loadPublicKeys(dir string) (map[string]publicKeys, error) {
+	domains, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := map[string]publicKeys{}
+	for _, dom := range domains {
+		// ConfigMap volumes contain hidden bookkeeping entries
+		if strings.HasPrefix(dom.Name(), ".") {
+			continue
+		}
+		if !domainRegexp.MatchString(dom.Name()) {
+			return nil, fmt.Errorf("invalid domain directory '%s'", dom.Name())
+		}
+
+		files, err := os.ReadDir(filepath.Join(dir, dom.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			if strings.HasPrefix(f.Name(), ".") {
+				continue
+			}
+			if !keyNameRegexp.MatchString(f.Name()) {
+				return nil, fmt.Errorf("invalid public key name '%s/%s'", dom.Name(), f.Name())
+			}
+			b, err := os.ReadFile(filepath.Join(dir, dom.Name(), f.Name()))
+			if err != nil {
+				return nil, err
+			}
+			if err := validatePublicKey(b); err != nil {
+				return nil, fmt.Errorf("invalid public key %s/%s: %w", dom.Name(), f.Name(), err)
+			}
+			if keys[dom.Name()] == nil {
+				keys[dom.Name()] = publicKeys{}
+			}
+			keys[dom.Name()][f.Name()] = b
+		}
+	}
+	return keys, nil
+}
+
+// installPublicKeys adds keys to the keys directory of a domain, and makes it the
+// CVMFS_KEYS_DIR of the domain. The keys the config repository provides for the
+// domain are copied along, so they keep working. Existing keys are never replaced.
+func installPublicKeys(domain string, keys publicKeys) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	if !domainRegexp.MatchString(domain) {
+		return fmt.Errorf("invalid domain '%s'", domain)
+	}
+	log := internal.GetLogger("installPublicKeys").With().Str("domain", domain).Logger()
+
+	dir := path.Join(CVMFSKeysDir, domain)
+	if err := mkdir(dir); err != nil {
+		return fmt.Errorf("cannot create keys directory %s: %w", dir, err)
+	}
+
+	all := configRepoKeys(domain)
+	for name, b := range keys {
+		all[name] = b
+	}
+
+	for name, b := range all {
+		p := path.Join(dir, name)
+		current, err := os.ReadFile(p)
+		switch {
+		case err == nil && bytes.Equal(current, b):
+			continue
+		case err == nil:
+			if _, ok := keys[name]; ok {
+				return fmt.Errorf("%w: public key %s differs from the installed one", errConfigConflict, p)
+			}
+			continue
+		case !os.IsNotExist(err):
+			return err
+		}
+		if err := os.WriteFile(p, b, 0644); err != nil {
+			return fmt.Errorf("cannot write public key %s: %w", p, err)
+		}
+		log.Info().Str("key", p).Msg("public key installed")
+	}
+
+	conf := []byte(fmt.Sprintf("# Code generated by CSI Driver; DO NOT EDIT.\nCVMFS_KEYS_DIR=%s\n", dir))
+	confPath := path.Join(CVMFSDomainDir, domain+".local")
+	if current, err := os.ReadFile(confPath); err == nil && bytes.Equal(current, conf) {
+		return nil
+	}
+	if err := mkdir(CVMFSDomainDir); err != nil {
+		return err
+	}
+	return os.WriteFile(confPath, conf, 0644)
+}
+
+// configRepoKeys returns the keys the config repository has for a domain
+func configRepoKeys(domain string) publicKeys {
+	keys := publicKeys{}
+	dir := path.Join(CVMFSConfigRepo.getMountPath(), "etc/cvmfs/keys", domain)
+	if files, err := os.ReadDir(dir); err == nil {
+		for _, f := range files {
+			if b, err := os.ReadFile(path.Join(dir, f.Name())); err == nil && strings.HasSuffix(f.Name(), publicKeySuffix) {
+				keys[f.Name()] = b
+			}
+		}
+	}
+	return keys
+}
+
+// setupPublicKeys installs the keys configured for the driver,
+// and the keys a volume of repository r brought along
+func (d *Driver) setupPublicKeys(r Repository, keys publicKeys) error {
+	for domain, configured := range d.publicKeys {
+		if err := installPublicKeys(domain, configured); err != nil {
+			return fmt.Errorf("cannot install public keys of %s: %w", domain, err)
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	if r.domain() == "" {
+		return fmt.Errorf("repository %s has no domain to install public keys for", r)
+	}
+	return installPublicKeys(r.domain(), keys)
+}
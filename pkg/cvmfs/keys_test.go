@@ -0,0 +1,179 @@
+// Copyright CERN.
+//
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cvmfs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// pemPublicKey encodes a public key like the .pub files of cvmfs
+func pemPublicKey(t *testing.T, pub interface{}) string {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+func testKeys(t *testing.T) (rsaKey, ecKey string) {
+	r, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pemPublicKey(t, &r.PublicKey), pemPublicKey(t, &e.PublicKey)
+}
+
+func TestValidatePublicKey(t *testing.T) {
+	rsaKey, ecKey := testKeys(t)
+	tests := []struct {
+		name    string
+		key     string
+		wantErr bool
+	}{
+		{name: "rsa", key: rsaKey},
+		{name: "rsa with trailing newlines", key: rsaKey + "\n\n"},
+		{name: "ecdsa", key: ecKey, wantErr: true},
+		{name: "trailing data", key: rsaKey + "garbage", wantErr: true},
+		{name: "not pem", key: "ssh-rsa AAAA", wantErr: true},
+		{name: "other pem type", key: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte("x")})), wantErr: true},
+		{name: "corrupt key", key: string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: []byte("x")})), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validatePublicKey([]byte(tt.key)); (err != nil) != tt.wantErr {
+				t.Errorf("validatePublicKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPublicKeysFromSecrets(t *testing.T) {
+	rsaKey, ecKey := testKeys(t)
+	tests := []struct {
+		name     string
+		secrets  map[string]string
+		wantKeys []string
+		wantErr  bool
+	}{
+		{name: "none", secrets: nil},
+		{name: "keys among other secrets", secrets: map[string]string{"example.org.pub": rsaKey, "token": "secret"}, wantKeys: []string{"example.org.pub"}},
+		{name: "invalid name", secrets: map[string]string{"../example.org.pub": rsaKey}, wantErr: true},
+		{name: "hidden name", secrets: map[string]string{".example.org.pub": rsaKey}, wantErr: true},
+		{name: "invalid key", secrets: map[string]string{"example.org.pub": ecKey}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys, err := publicKeysFromSecrets(tt.secrets)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("publicKeysFromSecrets() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(keys) != len(tt.wantKeys) {
+				t.Fatalf("publicKeysFromSecrets() = %d keys, want %q", len(keys), tt.wantKeys)
+			}
+			for _, name := range tt.wantKeys {
+				if string(keys[name]) != tt.secrets[name] {
+					t.Errorf("publicKeysFromSecrets() is missing %s", name)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadPublicKeys(t *testing.T) {
+	rsaKey, ecKey := testKeys(t)
+	write := func(t *testing.T, dir string, files map[string]string) {
+		for name, content := range files {
+			p := filepath.Join(dir, name)
+			if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	tests := []struct {
+		name    string
+		files   map[string]string
+		want    map[string][]string
+		wantErr bool
+	}{
+		{
+			name: "configmap",
+			files: map[string]string{
+				"example.org/example.org.pub": rsaKey,
+				"example.org/other.pub":       rsaKey,
+				"cern.ch/extra.pub":           rsaKey,
+				"..data/ignored":              "x",
+				"example.org/..data":          "x",
+			},
+			want: map[string][]string{"example.org": {"example.org.pub", "other.pub"}, "cern.ch": {"extra.pub"}},
+		},
+		{name: "invalid domain", files: map[string]string{"bad_domain/a.pub": rsaKey}, wantErr: true},
+		{name: "not a key file", files: map[string]string{"example.org/README": "x"}, wantErr: true},
+		{name: "invalid key", files: map[string]string{"example.org/a.pub": ecKey}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			write(t, dir, tt.files)
+			keys, err := loadPublicKeys(dir)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadPublicKeys() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(keys) != len(tt.want) {
+				t.Fatalf("loadPublicKeys() = %d domains, want %v", len(keys), tt.want)
+			}
+			for domain, names := range tt.want {
+				if len(keys[domain]) != len(names) {
+					t.Errorf("loadPublicKeys() has %d keys for %s, want %q", len(keys[domain]), domain, names)
+				}
+				for _, name := range names {
+					if string(keys[domain][name]) != rsaKey {
+						t.Errorf("loadPublicKeys() is missing %s/%s", domain, name)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestRepositoryDomain(t *testing.T) {
+	tests := map[Repository]string{
+		"atlas.cern.ch":               "cern.ch",
+		"dunedaq.opensciencegrid.org": "opensciencegrid.org",
+		"localhost":                   "",
+	}
+	for r, want := range tests {
+		if got := r.domain(); got != want {
+			t.Errorf("domain() of %s = %q, want %q", r, got, want)
+		}
+	}
+}
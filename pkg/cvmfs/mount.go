@@ -3,9 +3,11 @@ package cvmfs
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/cernops/cvmfs-csi/internal"
-	"k8s.io/mount-utils"
 
 	_ "embed"
 )
@@ -14,6 +16,7 @@ var CVMFSConfigRepo = Repository("cvmfs-config.cern.ch")
 
 const (
 	CVMFSLocalConfigFile = "/etc/cvmfs/default.local"
+	CVMFSConfigDir       = "/etc/cvmfs/config.d"
 )
 
 // MountCVMFS mounts a given repository name to the /cvmfs/<repository> folder
@@ -27,18 +30,36 @@ func MountCVMFS(r Repository) error {
 	}
 
 	log = log.With().Str("path", r.getMountPath()).Str("repository", string(r)).Logger()
-	if _, err := execCommand("/usr/bin/mount", "-t", "cvmfs", string(r), r.getMountPath()); err != nil {
-		log.Error().Err(err).Msg("mount failed")
-		return err
+	if out, err := execCommand("/usr/bin/mount", "-t", "cvmfs", string(r), r.getMountPath()); err != nil {
+		log.Error().Err(err).Bytes("output", out).Msg("mount failed")
+		return newMountError(r, string(out), err)
 	}
 
 	log.Info().Msg("mounted")
 	return nil
 }
 
-// Unmount unmounts the given path
+// mountHelper bind-mounts and unmounts for a node plugin that runs unprivileged with fuse
+// file descriptor passing, so it never mounts itself. NewDriver sets it, in the mount
+// holder, which mounts for the node plugin, it stays nil.
+var mountHelper *mountHolderClient
+
+// Unmount unmounts the given path, including anything mounted below it
 func Unmount(mountpath string) error {
-	_, err := execCommand("umount", mountpath)
+	if mountHelper != nil {
+		return mountHelper.Unmount(mountpath, false)
+	}
+
+	recursive, err := hasSubmounts(mountpath)
+	if err != nil {
+		return fmt.Errorf("cannot probe submounts of %s: %w", mountpath, err)
+	}
+
+	if recursive {
+		_, err = execCommand("umount", "--recursive", mountpath)
+	} else {
+		_, err = execCommand("umount", mountpath)
+	}
 	return err
 }
 
@@ -46,16 +67,198 @@ func mkdir(path string) error {
 	return os.MkdirAll(path, 0755)
 }
 
-func folderIsMounted(path string) (bool, error) {
-	not, err := mount.IsNotMountPoint(mount.New(""), path)
-	return !not, err
+// resolveSubdirectory returns the absolute path of subdirectory within root,
+// following symlinks, and fails if it does not exist or lies outside of root
+func resolveSubdirectory(root, subdirectory string) (string, error) {
+	resolved, err := resolveWithin(root, subdirectory)
+	if err != nil {
+		return "", err
+	}
+
+	fi, err := os.Stat(resolved)
+	if err != nil {
+		return "", err
+	}
+	if !fi.IsDir() {
+		return "", fmt.Errorf("subdirectory %s is not a directory", subdirectory)
+	}
+
+	return resolved, nil
+}
+
+// resolveWithin resolves the relative path p below root, following symlinks,
+// and makes sure the result stays below root
+func resolveWithin(root, p string) (string, error) {
+	joined := filepath.Join(root, p)
+	if joined != root && !strings.HasPrefix(joined, root+"/") {
+		return "", fmt.Errorf("%s is outside of %s", p, root)
+	}
+
+	resolved, err := filepath.EvalSymlinks(joined)
+	if err != nil {
+		return "", fmt.Errorf("cannot resolve %s: %w", p, err)
+	}
+	if resolved != root && !strings.HasPrefix(resolved, root+"/") {
+		return "", fmt.Errorf("%s resolves to %s, which is outside of %s", p, resolved, root)
+	}
+	return resolved, nil
+}
+
+type mountStatus int
+
+const (
+	notMounted mountStatus = iota
+	mountHealthy
+	// mountBroken is a mount that cannot be accessed anymore,
+	// typically because its fuse daemon has died
+	mountBroken
+	// mountWrong is a healthy mount of something else than expected
+	mountWrong
+)
+
+// probeMount checks if path is a mount point, and if so if it is usable
+func probeMount(path string) (mountStatus, error) {
+	info, err := inspectMount(path)
+	if err != nil || info == nil {
+		return notMounted, err
+	}
+	if !info.Healthy {
+		return mountBroken, nil
+	}
+	return mountHealthy, nil
+}
+
+// lazyUnmount detaches a mount even when it is busy or its fuse daemon hangs
+func lazyUnmount(mountpath string) error {
+	if mountHelper != nil {
+		return mountHelper.Unmount(mountpath, true)
+	}
+	_, err := execCommand("umount", "--lazy", mountpath)
+	return err
+}
+
+const unmountAttempts = 3
+
+// unmountBackoff is the time between unmount attempts, a variable for tests
+var unmountBackoff = time.Second
+
+// The mount operations of unmountAndRemove, replaced in tests
+var (
+	probeMountOp  = probeMount
+	unmountOp     = Unmount
+	lazyUnmountOp = lazyUnmount
+)
+
+// unmountAndRemove unmounts path and deletes the mount point, and is idempotent:
+// a path that does not exist or is not mounted is cleaned up without error.
+// Healthy mounts are unmounted with a few retries, broken fuse mounts are detached lazily
+// since they cannot be unmounted otherwise. Whether path is mounted is decided from
+// mountinfo before path is accessed, so a hung fuse mount does not block.
+// An error is returned if path is still mounted afterwards.
+func unmountAndRemove(path string) error {
+	log := internal.GetLogger("unmountAndRemove").With().Str("path", path).Logger()
+
+	st, err := probeMountOp(path)
+	if err != nil {
+		return fmt.Errorf("cannot probe mount state: %w", err)
+	}
+
+	// bind mounts can be stacked, keep going until nothing is left
+	for i := 1; st != notMounted; i++ {
+		if i > unmountAttempts {
+			if err != nil {
+				return fmt.Errorf("%s is still mounted after %d attempts: %w", path, unmountAttempts, err)
+			}
+			return fmt.Errorf("%s is still mounted after %d attempts", path, unmountAttempts)
+		}
+
+		if st == mountBroken {
+			log.Warn().Msg("mount is broken, detaching lazily")
+			err = lazyUnmountOp(path)
+		} else {
+			err = unmountOp(path)
+		}
+		if err != nil {
+			log.Warn().Err(err).Int("attempt", i).Msg("unmount failed, retrying")
+			time.Sleep(unmountBackoff)
+		}
+
+		var perr error
+		if st, perr = probeMountOp(path); perr != nil {
+			return fmt.Errorf("cannot probe mount state: %w", perr)
+		}
+	}
+
+	if err := os.Remove(path); os.IsNotExist(err) {
+		log.Debug().Msg("path does not exist, nothing to remove")
+	} else if err != nil {
+		return fmt.Errorf("cannot remove mount point: %w", err)
+	}
+	return nil
+}
+
+// permittedMountFlags are the mount options that may be requested through
+// a PersistentVolume's mountOptions. Anything making the volume writable is not.
+var permittedMountFlags = map[string]bool{
+	"ro":          true,
+	"nosuid":      true,
+	"nodev":       true,
+	"noexec":      true,
+	"noatime":     true,
+	"nodiratime":  true,
+	"relatime":    true,
+	"strictatime": true,
+}
+
+// parseMountFlags splits and validates the mount flags of a VolumeCapability
+func parseMountFlags(flags []string) ([]string, error) {
+	var parsed []string
+	for _, f := range flags {
+		for _, o := range strings.Split(f, ",") {
+			o = strings.TrimSpace(o)
+			if o == "" {
+				continue
+			}
+			if !permittedMountFlags[o] {
+				return nil, fmt.Errorf("mount flag '%s' is not permitted", o)
+			}
+			parsed = append(parsed, o)
+		}
+	}
+	return parsed, nil
+}
+
+// remountOptions builds the options for a read-only bind remount with extra flags
+func remountOptions(flags []string) string {
+	return strings.Join(append([]string{"remount", "ro", "bind"}, flags...), ",")
 }
 
-func bindMount(from, to string) error {
+// bindMount bind-mounts from onto to read-only, with extra flags
+func bindMount(from, to string, flags ...string) error {
+	if mountHelper != nil {
+		return mountHelper.Bind(from, to, flags)
+	}
+
 	if _, err := execCommand("mount", "--bind", from, to); err != nil {
 		return fmt.Errorf("failed bind-mount of %s to %s: %v", from, to, err)
 	}
 
-	_, err := execCommand("mount", "-o", "remount,ro,bind", to)
+	_, err := execCommand("mount", "-o", remountOptions(flags), to)
+	return err
+}
+
+// rbindMount recursively bind-mounts from onto to as a read-only slave, so mounts
+// appearing below from later on (e.g. by autofs) also show up below to. It is only
+// used with automount, which the mountHelper does not support.
+func rbindMount(from, to string, flags ...string) error {
+	if _, err := execCommand("mount", "--rbind", from, to); err != nil {
+		return fmt.Errorf("failed recursive bind-mount of %s to %s: %v", from, to, err)
+	}
+
+	if _, err := execCommand("mount", "--make-rslave", to); err != nil {
+		return fmt.Errorf("failed to make %s a slave mount: %v", to, err)
+	}
+
+	_, err := execCommand("mount", "-o", remountOptions(flags), to)
 	return err
 }
@@ -0,0 +1,129 @@
+// Copyright CERN.
+//
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cvmfs
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUnmountAndRemove(t *testing.T) {
+	tests := []struct {
+		name string
+		// exists creates the mount point
+		exists bool
+		// states are what probeMount returns, one per call; the last one repeats
+		states      []mountStatus
+		unmountErr  error
+		wantErr     bool
+		wantUnmount int
+		wantLazy    int
+		wantRemoved bool
+	}{
+		{
+			name:        "path does not exist",
+			states:      []mountStatus{notMounted},
+			wantRemoved: true,
+		},
+		{
+			name:        "not mounted",
+			exists:      true,
+			states:      []mountStatus{notMounted},
+			wantRemoved: true,
+		},
+		{
+			name:        "healthy",
+			exists:      true,
+			states:      []mountStatus{mountHealthy, notMounted},
+			wantUnmount: 1,
+			wantRemoved: true,
+		},
+		{
+			name:        "stacked bind mounts",
+			exists:      true,
+			states:      []mountStatus{mountHealthy, mountHealthy, notMounted},
+			wantUnmount: 2,
+			wantRemoved: true,
+		},
+		{
+			name:        "broken",
+			exists:      true,
+			states:      []mountStatus{mountBroken, notMounted},
+			wantLazy:    1,
+			wantRemoved: true,
+		},
+		{
+			name:        "still mounted after retries",
+			exists:      true,
+			states:      []mountStatus{mountHealthy},
+			unmountErr:  errors.New("target is busy"),
+			wantErr:     true,
+			wantUnmount: unmountAttempts,
+		},
+	}
+
+	defer func(probe func(string) (mountStatus, error), unmount, lazy func(string) error) {
+		probeMountOp, unmountOp, lazyUnmountOp = probe, unmount, lazy
+	}(probeMountOp, unmountOp, lazyUnmountOp)
+	unmountBackoff = 0
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "mnt")
+			if tt.exists {
+				if err := os.Mkdir(path, 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			probes, unmounts, lazies := 0, 0, 0
+			probeMountOp = func(string) (mountStatus, error) {
+				st := tt.states[len(tt.states)-1]
+				if probes < len(tt.states) {
+					st = tt.states[probes]
+				}
+				probes++
+				return st, nil
+			}
+			unmountOp = func(string) error {
+				unmounts++
+				return tt.unmountErr
+			}
+			lazyUnmountOp = func(string) error {
+				lazies++
+				return nil
+			}
+
+			err := unmountAndRemove(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unmountAndRemove() error = %v, want error %t", err, tt.wantErr)
+			}
+			if unmounts != tt.wantUnmount {
+				t.Errorf("unmounted %d times, want %d", unmounts, tt.wantUnmount)
+			}
+			if lazies != tt.wantLazy {
+				t.Errorf("detached lazily %d times, want %d", lazies, tt.wantLazy)
+			}
+			_, statErr := os.Stat(path)
+			if removed := os.IsNotExist(statErr); removed != tt.wantRemoved {
+				t.Errorf("mount point removed = %t, want %t", removed, tt.wantRemoved)
+			}
+		})
+	}
+}
@@ -0,0 +1,248 @@
+// Copyright CERN.
+//
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cvmfs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// mountErrorKind tells why mounting a repository failed, and whether retrying can help
+type mountErrorKind int
+
+const (
+	mountErrorUnknown mountErrorKind = iota
+	// mountErrorNotFound: the repository does not exist or is not configured
+	mountErrorNotFound
+	// mountErrorNetwork: no proxy or stratum server could be reached
+	mountErrorNetwork
+	// mountErrorPermission: the client was not allowed to mount
+	mountErrorPermission
+	// mountErrorConfig: the client configuration is invalid
+	mountErrorConfig
+	// mountErrorSignature: the repository could not be verified with the known keys
+	mountErrorSignature
+	// mountErrorCache: the cache cannot be used or is full
+	mountErrorCache
+	// mountErrorTimeout: the mount did not finish in time
+	mountErrorTimeout
+)
+
+func (k mountErrorKind) String() string {
+	switch k {
+	case mountErrorNotFound:
+		return "not found"
+	case mountErrorNetwork:
+		return "network"
+	case mountErrorPermission:
+		return "permission"
+	case mountErrorConfig:
+		return "config"
+	case mountErrorSignature:
+		return "signature"
+	case mountErrorCache:
+		return "cache"
+	case mountErrorTimeout:
+		return "timeout"
+	}
+	return "unknown"
+}
+
+// Code returns the gRPC status code for a mount failure of this kind.
+// Kubelet retries all of them, but the code tells users if that can succeed.
+func (k mountErrorKind) Code() codes.Code {
+	switch k {
+	case mountErrorNotFound:
+		return codes.NotFound
+	case mountErrorNetwork:
+		return codes.Unavailable
+	case mountErrorPermission:
+		return codes.PermissionDenied
+	case mountErrorConfig, mountErrorSignature:
+		return codes.FailedPrecondition
+	case mountErrorCache:
+		return codes.ResourceExhausted
+	case mountErrorTimeout:
+		return codes.DeadlineExceeded
+	}
+	return codes.Internal
+}
+
+// Reason returns the Kubernetes event reason for a mount failure of this kind
+func (k mountErrorKind) Reason() string {
+	switch k {
+	case mountErrorNotFound:
+		return "CVMFSRepositoryUnknown"
+	case mountErrorNetwork:
+		return "CVMFSNetworkFailure"
+	case mountErrorPermission:
+		return "CVMFSPermissionDenied"
+	case mountErrorConfig:
+		return "CVMFSConfigInvalid"
+	case mountErrorSignature:
+		return "CVMFSSignatureFailure"
+	case mountErrorCache:
+		return "CVMFSCacheFull"
+	case mountErrorTimeout:
+		return "CVMFSMountTimeout"
+	}
+	return "CVMFSMountFailed"
+}
+
+// mountError is a failed mount of a repository, along with what the client printed
+type mountError struct {
+	Repository Repository
+	Kind       mountErrorKind
+	// ExitCode is the failure code of cvmfs2, or 0 if unknown
+	ExitCode int
+	Output   string
+	Err      error
+}
+
+func newMountError(r Repository, output string, err error) *mountError {
+	e := &mountError{Repository: r, Output: strings.TrimSpace(output), Err: err}
+	e.ExitCode = cvmfsFailureCode(e.Output, err)
+	e.Kind = classifyMountFailure(e.Output, e.ExitCode, err)
+	return e
+}
+
+func (e *mountError) Error() string {
+	if e.Output == "" {
+		return fmt.Sprintf("mounting %s failed (%s): %v", e.Repository, e.Kind, e.Err)
+	}
+	return fmt.Sprintf("mounting %s failed (%s): %v: %s", e.Repository, e.Kind, e.Err, e.Output)
+}
+
+func (e *mountError) Unwrap() error {
+	return e.Err
+}
+
+// mountErrorStatus turns an error from mounting a repository into a gRPC status error
+func mountErrorStatus(err error, format string, a ...interface{}) error {
+	code := codes.Internal
+	var merr *mountError
+	if errors.As(err, &merr) {
+		code = merr.Kind.Code()
+	}
+	return status.Error(code, fmt.Sprintf(format, a...))
+}
+
+// cvmfs2 prints failures as e.g. "Failed to initialize root file catalog (16 - file catalog failure)"
+var cvmfsFailureRegexp = regexp.MustCompile(`\((\d+) - [^)]*\)`)
+
+// cvmfsFailureCode returns the failure code of cvmfs2, from its output or the exit code
+// of the mount helper, which passes it on
+func cvmfsFailureCode(output string, err error) int {
+	if m := cvmfsFailureRegexp.FindStringSubmatch(output); m != nil {
+		if code, err := strconv.Atoi(m[1]); err == nil {
+			return code
+		}
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	return 0
+}
+
+// cvmfsFailureKinds maps the failure codes of cvmfs2 (loader.h) with an unambiguous meaning.
+// Codes 1 and 2 are left out, as mount(8) uses them for its own errors.
+var cvmfsFailureKinds = map[int]mountErrorKind{
+	3:  mountErrorPermission, // kFailPermission
+	7:  mountErrorConfig,     // kFailLoadLibrary
+	8:  mountErrorConfig,     // kFailIncompatibleVersions
+	9:  mountErrorCache,      // kFailCacheDir
+	10: mountErrorCache,      // kFailPeers
+	12: mountErrorCache,      // kFailQuota
+	17: mountErrorNetwork,    // kFailMaintenanceMode
+	23: mountErrorNetwork,    // kFailWpad
+	25: mountErrorSignature,  // kFailRevisionBlacklisted
+}
+
+// classifyMountFailure determines the kind of a mount failure. The failure code is
+// preferred, the output of the client is searched for the remaining cases.
+func classifyMountFailure(output string, code int, err error) mountErrorKind {
+	switch {
+	case errors.Is(err, errCommandTimeout):
+		return mountErrorTimeout
+	case errors.Is(err, os.ErrNotExist):
+		return mountErrorNotFound
+	case errors.Is(err, os.ErrPermission):
+		return mountErrorPermission
+	}
+
+	if kind, ok := cvmfsFailureKinds[code]; ok {
+		return kind
+	}
+	return classifyMountOutput(output)
+}
+
+// classifyMountOutput determines the kind of a mount failure from what the client printed
+func classifyMountOutput(output string) mountErrorKind {
+	out := strings.ToLower(output)
+	contains := func(substrings ...string) bool {
+		for _, s := range substrings {
+			if strings.Contains(out, s) {
+				return true
+			}
+		}
+		return false
+	}
+
+	switch {
+	case contains("timed out", "timeout"):
+		return mountErrorTimeout
+	case contains("signature", "certificate", "whitelist", "public key"):
+		return mountErrorSignature
+	case contains("no space left", "cache is full", "quota", "cache directory"):
+		return mountErrorCache
+	case contains("permission denied", "operation not permitted"):
+		return mountErrorPermission
+	case contains("not configured", "not found", "404", "no such repository", "name mismatch"):
+		return mountErrorNotFound
+	case contains("proxy", "connection refused", "network", "resolve", "all hosts"):
+		return mountErrorNetwork
+	case contains("config", "invalid", "parameter"):
+		return mountErrorConfig
+	}
+	return mountErrorUnknown
+}
+
+// mountFailureKind returns the kind of a mount failure. gRPC status errors only
+// carry a message, which includes the kind or at least the output of the client.
+func mountFailureKind(err error) mountErrorKind {
+	var merr *mountError
+	if errors.As(err, &merr) {
+		return merr.Kind
+	}
+
+	msg := status.Convert(err).Message()
+	for k := mountErrorNotFound; k <= mountErrorTimeout; k++ {
+		if strings.Contains(msg, fmt.Sprintf(" failed (%s): ", k)) {
+			return k
+		}
+	}
+	return classifyMountOutput(msg)
+}
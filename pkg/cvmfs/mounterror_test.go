@@ -0,0 +1,116 @@
+// Copyright CERN.
+//
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cvmfs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestNewMountError(t *testing.T) {
+	failed := errors.New("exit status 32")
+	tests := []struct {
+		name     string
+		output   string
+		err      error
+		wantCode int
+		wantKind mountErrorKind
+	}{
+		{
+			name:     "failure code in output",
+			output:   "CernVM-FS: loading Fuse module... Failed to initialize root file catalog (16 - file catalog failure)",
+			err:      failed,
+			wantCode: 16,
+			wantKind: mountErrorUnknown,
+		},
+		{name: "quota", output: "Failed to initialize cache (12 - cache quota)", err: failed, wantCode: 12, wantKind: mountErrorCache},
+		{name: "permission code", output: "Failed (3 - permission denied)", err: failed, wantCode: 3, wantKind: mountErrorPermission},
+		{name: "blacklisted", output: "(25 - revision blacklisted)", err: failed, wantCode: 25, wantKind: mountErrorSignature},
+		{name: "not configured", output: "Repository foo.cern.ch is not configured", err: failed, wantKind: mountErrorNotFound},
+		{name: "proxy", output: "Failed to connect to proxy, all hosts failed", err: failed, wantKind: mountErrorNetwork},
+		{name: "whitelist", output: "failed to verify whitelist signature", err: failed, wantKind: mountErrorSignature},
+		{name: "no space", output: "write failed: No space left on device", err: failed, wantKind: mountErrorCache},
+		{name: "invalid config", output: "invalid CVMFS_STRICT_MOUNT parameter", err: failed, wantKind: mountErrorConfig},
+		{name: "output timeout", output: "mount timed out", err: failed, wantKind: mountErrorTimeout},
+		{name: "command timeout", output: "Failed to connect to proxy", err: fmt.Errorf("mount: %w", errCommandTimeout), wantKind: mountErrorTimeout},
+		{name: "missing binary", err: &os.PathError{Op: "exec", Path: "/usr/bin/cvmfs2", Err: os.ErrNotExist}, wantKind: mountErrorNotFound},
+		{name: "not permitted", err: &os.PathError{Op: "mount", Path: "/cvmfs/x", Err: os.ErrPermission}, wantKind: mountErrorPermission},
+		{name: "unknown", output: "something else", err: failed, wantKind: mountErrorUnknown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newMountError("atlas.cern.ch", tt.output, tt.err)
+			if e.ExitCode != tt.wantCode || e.Kind != tt.wantKind {
+				t.Errorf("newMountError() = code %d, kind %s, want code %d, kind %s", e.ExitCode, e.Kind, tt.wantCode, tt.wantKind)
+			}
+			if !errors.Is(e, tt.err) {
+				t.Errorf("newMountError() does not wrap %v", tt.err)
+			}
+		})
+	}
+}
+
+func TestMountErrorStatus(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want codes.Code
+	}{
+		{name: "not found", err: &mountError{Kind: mountErrorNotFound}, want: codes.NotFound},
+		{name: "network", err: &mountError{Kind: mountErrorNetwork}, want: codes.Unavailable},
+		{name: "signature", err: &mountError{Kind: mountErrorSignature}, want: codes.FailedPrecondition},
+		{name: "cache", err: &mountError{Kind: mountErrorCache}, want: codes.ResourceExhausted},
+		{name: "timeout", err: &mountError{Kind: mountErrorTimeout}, want: codes.DeadlineExceeded},
+		{name: "unknown", err: &mountError{Kind: mountErrorUnknown}, want: codes.Internal},
+		{name: "wrapped", err: fmt.Errorf("staging: %w", &mountError{Kind: mountErrorPermission}), want: codes.PermissionDenied},
+		{name: "other error", err: errors.New("boom"), want: codes.Internal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := mountErrorStatus(tt.err, "cannot mount: %v", tt.err)
+			if got := status.Code(err); got != tt.want {
+				t.Errorf("mountErrorStatus() code = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMountFailureKind(t *testing.T) {
+	merr := &mountError{Repository: "atlas.cern.ch", Kind: mountErrorSignature, Err: errors.New("exit status 32"), Output: "bad"}
+	tests := []struct {
+		name string
+		err  error
+		want mountErrorKind
+	}{
+		{name: "mount error", err: merr, want: mountErrorSignature},
+		{name: "status with kind", err: mountErrorStatus(merr, "cannot mount: %v", merr), want: mountErrorSignature},
+		{name: "status with output only", err: status.Error(codes.Internal, "mount failed: Failed to connect to proxy"), want: mountErrorNetwork},
+		{name: "other", err: errors.New("boom"), want: mountErrorUnknown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mountFailureKind(tt.err); got != tt.want {
+				t.Errorf("mountFailureKind() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright CERN.
+//
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cvmfs
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+)
+
+func TestParseMountFlags(t *testing.T) {
+	tests := []struct {
+		name    string
+		flags   []string
+		want    []string
+		wantErr bool
+	}{
+		{name: "none", flags: nil, want: nil},
+		{name: "separate", flags: []string{"nosuid", "nodev"}, want: []string{"nosuid", "nodev"}},
+		{name: "comma separated", flags: []string{"nosuid,nodev", "noexec"}, want: []string{"nosuid", "nodev", "noexec"}},
+		{name: "spaces and empty entries", flags: []string{" noatime ,, ro", ""}, want: []string{"noatime", "ro"}},
+		{name: "writable", flags: []string{"nosuid", "rw"}, wantErr: true},
+		{name: "setuid", flags: []string{"suid"}, wantErr: true},
+		{name: "unknown option", flags: []string{"uid=1000"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseMountFlags(tt.flags)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseMountFlags() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseMountFlags() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRemountOptions(t *testing.T) {
+	tests := []struct {
+		flags []string
+		want  string
+	}{
+		{flags: nil, want: "remount,ro,bind"},
+		{flags: []string{"nosuid", "nodev"}, want: "remount,ro,bind,nosuid,nodev"},
+	}
+	for _, tt := range tests {
+		if got := remountOptions(tt.flags); got != tt.want {
+			t.Errorf("remountOptions(%q) = %s, want %s", tt.flags, got, tt.want)
+		}
+	}
+}
+
+func TestIsReadOnlyAccessMode(t *testing.T) {
+	tests := []struct {
+		mode csi.VolumeCapability_AccessMode_Mode
+		want bool
+	}{
+		{csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY, true},
+		{csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY, true},
+		{csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER, false},
+		{csi.VolumeCapability_AccessMode_MULTI_NODE_SINGLE_WRITER, false},
+		{csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER, false},
+		{csi.VolumeCapability_AccessMode_UNKNOWN, false},
+	}
+	for _, tt := range tests {
+		if got := isReadOnlyAccessMode(tt.mode); got != tt.want {
+			t.Errorf("isReadOnlyAccessMode(%s) = %t, want %t", tt.mode, got, tt.want)
+		}
+	}
+}
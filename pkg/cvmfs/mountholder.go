@@ -0,0 +1,440 @@
+// Copyright CERN.
+//
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cvmfs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cernops/cvmfs-csi/internal"
+)
+
+// The mount holder runs the cvmfs clients on behalf of the node plugin. It lives in a
+// container of its own, so the clients and with them the mounts of running pods survive
+// restarts and upgrades of the node plugin. Both talk HTTP over a unix socket.
+
+// mountHolderTimeout bounds a request to the mount holder, which runs commands with a timeout of their own
+const mountHolderTimeout = 2 * time.Minute
+
+// cvmfsConfigRoot is where the client configuration the driver manages lives
+const cvmfsConfigRoot = "/etc/cvmfs"
+
+// holderMountRequest asks the mount holder to mount a repository
+type holderMountRequest struct {
+	Repository Repository `json:"repository,omitempty"`
+	// Files are the client configuration files the driver manages, written before mounting,
+	// and Remove those that have to be gone
+	Files  map[string][]byte `json:"files,omitempty"`
+	Remove []string          `json:"remove,omitempty"`
+	// Target, Config, Env and User are set for clients the driver runs itself, see runClient
+	Target string      `json:"target,omitempty"`
+	Config []string    `json:"config,omitempty"`
+	Env    []string    `json:"env,omitempty"`
+	User   *clientUser `json:"user,omitempty"`
+	// From and Flags are set to bind-mount From onto Target, and Lazy to unmount
+	// Target lazily, for a node plugin that does not mount itself, see mountHelper
+	From  string   `json:"from,omitempty"`
+	Flags []string `json:"flags,omitempty"`
+	Lazy  bool     `json:"lazy,omitempty"`
+}
+
+// MountHolderConfig configures the mount holder
+type MountHolderConfig struct {
+	// Socket is the unix socket the node plugin talks to the mount holder on
+	Socket string
+	// StateDir and KubeletDir are those of the node plugin, the mount holder
+	// only mounts below them and on the mount paths of repositories
+	StateDir   string
+	KubeletDir string
+}
+
+// holderResult is the outcome of a request to the mount holder. Mount failures
+// keep their classification, so they are reported like local ones.
+type holderResult struct {
+	Failed      bool           `json:"failed,omitempty"`
+	MountFailed bool           `json:"mountFailed,omitempty"`
+	Kind        mountErrorKind `json:"kind,omitempty"`
+	ExitCode    int            `json:"exitCode,omitempty"`
+	Output      string         `json:"output,omitempty"`
+	Error       string         `json:"error,omitempty"`
+}
+
+func holderResultOf(err error) holderResult {
+	if err == nil {
+		return holderResult{}
+	}
+	res := holderResult{Failed: true, Error: err.Error()}
+	var merr *mountError
+	if errors.As(err, &merr) {
+		res.MountFailed = true
+		res.Kind = merr.Kind
+		res.ExitCode = merr.ExitCode
+		res.Output = merr.Output
+		res.Error = merr.Err.Error()
+	}
+	return res
+}
+
+func (res holderResult) err(r Repository) error {
+	switch {
+	case !res.Failed:
+		return nil
+	case res.MountFailed:
+		return &mountError{Repository: r, Kind: res.Kind, ExitCode: res.ExitCode, Output: res.Output, Err: errors.New(res.Error)}
+	}
+	return fmt.Errorf("mount holder: %s", res.Error)
+}
+
+// managedConfigFiles returns the client configuration the driver manages for mounting r,
+// or all repositories if r is empty, along with the managed files that do not exist
+func managedConfigFiles(r Repository) (map[string][]byte, []string, error) {
+	paths := []string{CVMFSLocalConfigFile}
+	if r != "" {
+		paths = append(paths, r.getConfigPath())
+		if domain := r.domain(); domain != "" {
+			paths = append(paths, path.Join(CVMFSDomainDir, domain+".local"))
+			keys, _ := filepath.Glob(path.Join(CVMFSKeysDir, domain, "*"+publicKeySuffix))
+			paths = append(paths, keys...)
+		}
+	}
+
+	files := map[string][]byte{}
+	var missing []string
+	for _, p := range paths {
+		b, err := os.ReadFile(p)
+		if os.IsNotExist(err) {
+			missing = append(missing, p)
+			continue
+		} else if err != nil {
+			return nil, nil, err
+		}
+		files[p] = b
+	}
+	return files, missing, nil
+}
+
+// isManagedConfigPath checks that p is a client configuration file, and not anything else
+func isManagedConfigPath(p string) bool {
+	return path.IsAbs(p) && path.Clean(p) == p && strings.HasPrefix(p, cvmfsConfigRoot+"/")
+}
+
+// syncConfigFiles makes the client configuration of the mount holder match the one of the node plugin
+func syncConfigFiles(files map[string][]byte, remove []string) error {
+	for p, b := range files {
+		if !isManagedConfigPath(p) {
+			return fmt.Errorf("refusing to write %s", p)
+		}
+		if current, err := os.ReadFile(p); err == nil && bytes.Equal(current, b) {
+			continue
+		}
+		if err := mkdir(path.Dir(p)); err != nil {
+			return err
+		}
+		if err := os.WriteFile(p, b, 0644); err != nil {
+			return err
+		}
+	}
+	for _, p := range remove {
+		if !isManagedConfigPath(p) {
+			return fmt.Errorf("refusing to remove %s", p)
+		}
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateClientRequest checks a request to run a client, which the node plugin only sends
+// with fuse file descriptor passing: the client runs as an unprivileged user, on the mount
+// path of the repository or below the isolated volumes of stateDir, and only gets the
+// credentials of isolated volumes added to its environment.
+func validateClientRequest(req *holderMountRequest, c MountHolderConfig) error {
+	if req.User == nil {
+		return errors.New("clients must run as a client user")
+	}
+	if req.User.UID == 0 {
+		return errors.New("clients must not run as root")
+	}
+
+	isolated := c.isolatedRoot()
+	if req.Target != req.Repository.getMountPath() && !isBelow(req.Target, isolated) {
+		return fmt.Errorf("invalid target %s", req.Target)
+	}
+	for _, c := range req.Config {
+		if !filepath.IsAbs(c) || strings.ContainsAny(c, ",:") {
+			return fmt.Errorf("invalid configuration file %s", c)
+		}
+	}
+
+	allowed := map[string]bool{}
+	for _, key := range credentialEnv {
+		allowed[key] = true
+	}
+	for _, e := range req.Env {
+		i := strings.Index(e, "=")
+		if i < 0 || !allowed[e[:i]] {
+			return fmt.Errorf("invalid environment variable %s", e)
+		}
+		if !isBelow(e[i+1:], isolated) {
+			return fmt.Errorf("invalid credentials path in %s", e[:i])
+		}
+	}
+	return nil
+}
+
+// validateMountPointRequest checks a request to bind-mount or unmount for the node plugin:
+// volumes are only mounted below the kubelet directory, from the mount paths of repositories,
+// isolated volumes or staging paths, and only those are unmounted.
+func validateMountPointRequest(req *holderMountRequest, c MountHolderConfig) error {
+	roots := []string{AutomountRoot, c.isolatedRoot(), c.KubeletDir}
+	if req.From != "" {
+		if !isBelow(req.From, roots...) {
+			return fmt.Errorf("invalid source %s", req.From)
+		}
+		roots = []string{c.KubeletDir}
+	}
+	if !isBelow(req.Target, roots...) {
+		return fmt.Errorf("invalid target %s", req.Target)
+	}
+	for _, f := range req.Flags {
+		if !permittedMountFlags[f] {
+			return fmt.Errorf("mount flag '%s' is not permitted", f)
+		}
+	}
+	return nil
+}
+
+// isolatedRoot is the directory the isolated volumes of the node plugin are kept in
+func (c MountHolderConfig) isolatedRoot() string {
+	return filepath.Join(c.StateDir, isolatedVolumesDir)
+}
+
+// isBelow checks that p is a clean absolute path below one of roots
+func isBelow(p string, roots ...string) bool {
+	if !filepath.IsAbs(p) || filepath.Clean(p) != p {
+		return false
+	}
+	for _, root := range roots {
+		if strings.HasPrefix(p, filepath.Clean(root)+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// RunMountHolder serves the mount holder API on a unix socket. It only returns on failure.
+func RunMountHolder(c MountHolderConfig) error {
+	log := internal.GetLogger("mountHolder").With().Str("socket", c.Socket).Logger()
+	socket := c.Socket
+
+	if err := os.Remove(socket); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("cannot remove stale socket: %w", err)
+	}
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(socket, 0600); err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/mount", handleHolderRequest(func(req *holderMountRequest) error {
+		if err := req.Repository.Validate(); err != nil {
+			return err
+		}
+		if err := syncConfigFiles(req.Files, req.Remove); err != nil {
+			return fmt.Errorf("cannot write client configuration: %w", err)
+		}
+		if req.Target == "" && req.User == nil {
+			return MountCVMFS(req.Repository)
+		}
+		if err := validateClientRequest(req, c); err != nil {
+			return err
+		}
+		return runClient(req.Repository, req.Target, req.Config, req.Env, req.User)
+	}))
+	mux.HandleFunc("/bind", handleHolderRequest(func(req *holderMountRequest) error {
+		if err := validateMountPointRequest(req, c); err != nil {
+			return err
+		}
+		return bindMount(req.From, req.Target, req.Flags...)
+	}))
+	mux.HandleFunc("/unmount", handleHolderRequest(func(req *holderMountRequest) error {
+		if err := validateMountPointRequest(req, c); err != nil {
+			return err
+		}
+		if req.Lazy {
+			return lazyUnmount(req.Target)
+		}
+		return Unmount(req.Target)
+	}))
+	mux.HandleFunc("/tmpfs", handleHolderRequest(func(req *holderMountRequest) error {
+		if !isBelow(req.Target, c.isolatedRoot()) || filepath.Base(req.Target) != credentialsDir {
+			return fmt.Errorf("invalid target %s", req.Target)
+		}
+		return mountCredentialsTmpfs(req.Target)
+	}))
+	mux.HandleFunc("/automount", handleHolderRequest(func(req *holderMountRequest) error {
+		if err := syncConfigFiles(req.Files, req.Remove); err != nil {
+			return fmt.Errorf("cannot write client configuration: %w", err)
+		}
+		return startAutomount()
+	}))
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	log.Info().Msg("mount holder listening")
+	return http.Serve(l, mux)
+}
+
+// handleHolderRequest decodes a request of the node plugin, and answers with the outcome of f
+func handleHolderRequest(f func(req *holderMountRequest) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := internal.GetLogger("mountHolder").With().Str("path", r.URL.Path).Logger()
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		req := &holderMountRequest{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			http.Error(w, fmt.Sprintf("cannot parse request: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		err := f(req)
+		if err != nil {
+			log.Error().Err(err).Str("repository", string(req.Repository)).Str("target", req.Target).Msg("request failed")
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(holderResultOf(err)); err != nil {
+			log.Error().Err(err).Msg("cannot send result")
+		}
+	}
+}
+
+// mountHolderClient makes the mount holder mount repositories for the node plugin
+type mountHolderClient struct {
+	client *http.Client
+}
+
+func newMountHolderClient(socket string) *mountHolderClient {
+	return &mountHolderClient{client: &http.Client{
+		Timeout: mountHolderTimeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		},
+	}}
+}
+
+func (c *mountHolderClient) call(endpoint string, req *holderMountRequest) (*holderResult, error) {
+	b, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	// the host is ignored, requests always go to the socket
+	resp, err := c.client.Post("http://mount-holder"+endpoint, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return nil, fmt.Errorf("cannot reach mount holder: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("mount holder answered %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	res := &holderResult{}
+	if err := json.NewDecoder(resp.Body).Decode(res); err != nil {
+		return nil, fmt.Errorf("cannot parse answer of mount holder: %w", err)
+	}
+	return res, nil
+}
+
+// Mount mounts repository r on its mount path
+func (c *mountHolderClient) Mount(r Repository) error {
+	return c.mount(&holderMountRequest{Repository: r})
+}
+
+// MountClient mounts repository r on to with a client the driver runs itself, see runClient
+func (c *mountHolderClient) MountClient(r Repository, to string, config []string, env []string, user *clientUser) error {
+	return c.mount(&holderMountRequest{Repository: r, Target: to, Config: config, Env: env, User: user})
+}
+
+func (c *mountHolderClient) mount(req *holderMountRequest) error {
+	var err error
+	if req.Files, req.Remove, err = managedConfigFiles(req.Repository); err != nil {
+		return fmt.Errorf("cannot read client configuration: %w", err)
+	}
+	res, err := c.call("/mount", req)
+	if err != nil {
+		return err
+	}
+	return res.err(req.Repository)
+}
+
+// Bind bind-mounts from onto to read-only with flags, see bindMount
+func (c *mountHolderClient) Bind(from, to string, flags []string) error {
+	return c.mountPoint("/bind", &holderMountRequest{From: from, Target: to, Flags: flags})
+}
+
+// Unmount unmounts to, lazily if asked to, see Unmount and lazyUnmount
+func (c *mountHolderClient) Unmount(to string, lazy bool) error {
+	return c.mountPoint("/unmount", &holderMountRequest{Target: to, Lazy: lazy})
+}
+
+// MountTmpfs mounts the tmpfs for the credentials of an isolated volume on to, see mountCredentialsTmpfs
+func (c *mountHolderClient) MountTmpfs(to string) error {
+	return c.mountPoint("/tmpfs", &holderMountRequest{Target: to})
+}
+
+func (c *mountHolderClient) mountPoint(endpoint string, req *holderMountRequest) error {
+	res, err := c.call(endpoint, req)
+	if err != nil {
+		return err
+	}
+	return res.err("")
+}
+
+// StartAutomount starts autofs in the mount holder, see startAutomount
+func (c *mountHolderClient) StartAutomount() error {
+	files, remove, err := managedConfigFiles("")
+	if err != nil {
+		return fmt.Errorf("cannot read client configuration: %w", err)
+	}
+	res, err := c.call("/automount", &holderMountRequest{Files: files, Remove: remove})
+	if err != nil {
+		return err
+	}
+	return res.err("")
+}
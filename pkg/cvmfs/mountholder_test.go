@@ -0,0 +1,94 @@
+// Copyright CERN.
+//
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cvmfs
+
+import "testing"
+
+func TestValidateClientRequest(t *testing.T) {
+	c := MountHolderConfig{StateDir: "/csi-data-dir", KubeletDir: "/var/lib/kubelet"}
+	user := &clientUser{UID: 1000, GID: 1000}
+	isolated := "/csi-data-dir/isolated/vol-1"
+
+	tests := []struct {
+		name    string
+		req     holderMountRequest
+		wantErr bool
+	}{
+		{name: "repository", req: holderMountRequest{Repository: "atlas.cern.ch", Target: "/cvmfs/atlas.cern.ch", User: user}},
+		{name: "isolated with credentials", req: holderMountRequest{
+			Repository: "atlas.cern.ch", Target: isolated + "/mnt", Config: []string{isolated + "/client.conf"},
+			Env: []string{"BEARER_TOKEN_FILE=" + isolated + "/credentials/token"}, User: user,
+		}},
+		{name: "no user", req: holderMountRequest{Repository: "atlas.cern.ch", Target: isolated + "/mnt"}, wantErr: true},
+		{name: "root", req: holderMountRequest{Repository: "atlas.cern.ch", Target: isolated + "/mnt", User: &clientUser{}}, wantErr: true},
+		{name: "mount path of another repository", req: holderMountRequest{Repository: "atlas.cern.ch", Target: "/cvmfs/cms.cern.ch", User: user}, wantErr: true},
+		{name: "target outside the state directory", req: holderMountRequest{Repository: "atlas.cern.ch", Target: "/etc", User: user}, wantErr: true},
+		{name: "target escaping the state directory", req: holderMountRequest{Repository: "atlas.cern.ch", Target: isolated + "/../../../etc", User: user}, wantErr: true},
+		{name: "relative target", req: holderMountRequest{Repository: "atlas.cern.ch", Target: "isolated/vol-1/mnt", User: user}, wantErr: true},
+		{name: "configuration list", req: holderMountRequest{Repository: "atlas.cern.ch", Target: isolated + "/mnt", Config: []string{"/a:/b"}, User: user}, wantErr: true},
+		{name: "other environment variable", req: holderMountRequest{
+			Repository: "atlas.cern.ch", Target: isolated + "/mnt", Env: []string{"LD_PRELOAD=" + isolated + "/credentials/token"}, User: user,
+		}, wantErr: true},
+		{name: "credentials outside the state directory", req: holderMountRequest{
+			Repository: "atlas.cern.ch", Target: isolated + "/mnt", Env: []string{"X509_USER_PROXY=/etc/shadow"}, User: user,
+		}, wantErr: true},
+		{name: "malformed environment variable", req: holderMountRequest{
+			Repository: "atlas.cern.ch", Target: isolated + "/mnt", Env: []string{"BEARER_TOKEN_FILE"}, User: user,
+		}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateClientRequest(&tt.req, c); (err != nil) != tt.wantErr {
+				t.Errorf("validateClientRequest() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateMountPointRequest(t *testing.T) {
+	c := MountHolderConfig{StateDir: "/csi-data-dir", KubeletDir: "/var/lib/kubelet"}
+	staging := "/var/lib/kubelet/plugins/kubernetes.io/csi/pv/pv-1/globalmount"
+	target := "/var/lib/kubelet/pods/uid/volumes/kubernetes.io~csi/pv-1/mount"
+
+	tests := []struct {
+		name    string
+		req     holderMountRequest
+		wantErr bool
+	}{
+		{name: "stage", req: holderMountRequest{From: "/cvmfs/atlas.cern.ch/sw", Target: staging}},
+		{name: "stage isolated", req: holderMountRequest{From: "/csi-data-dir/isolated/vol-1/mnt", Target: staging}},
+		{name: "publish", req: holderMountRequest{From: staging, Target: target, Flags: []string{"nosuid", "nodev"}}},
+		{name: "unmount", req: holderMountRequest{Target: target}},
+		{name: "unmount repository", req: holderMountRequest{Target: "/cvmfs/atlas.cern.ch", Lazy: true}},
+		{name: "unmount isolated", req: holderMountRequest{Target: "/csi-data-dir/isolated/vol-1/credentials"}},
+		{name: "source outside", req: holderMountRequest{From: "/etc", Target: target}, wantErr: true},
+		{name: "source escaping", req: holderMountRequest{From: "/cvmfs/../etc", Target: target}, wantErr: true},
+		{name: "state directory", req: holderMountRequest{From: "/csi-data-dir", Target: target}, wantErr: true},
+		{name: "bind onto repository", req: holderMountRequest{From: staging, Target: "/cvmfs/atlas.cern.ch"}, wantErr: true},
+		{name: "bind onto host", req: holderMountRequest{From: "/cvmfs/atlas.cern.ch", Target: "/usr/bin"}, wantErr: true},
+		{name: "writable", req: holderMountRequest{From: staging, Target: target, Flags: []string{"rw"}}, wantErr: true},
+		{name: "unmount host", req: holderMountRequest{Target: "/proc"}, wantErr: true},
+		{name: "unmount kubelet directory", req: holderMountRequest{Target: "/var/lib/kubelet"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateMountPointRequest(&tt.req, c); (err != nil) != tt.wantErr {
+				t.Errorf("validateMountPointRequest() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,173 @@
+// Copyright CERN.
+//
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cvmfs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"k8s.io/mount-utils"
+)
+
+// mountInfo describes the topmost mount on a path
+type mountInfo struct {
+	mountEntry
+	// Healthy is false when the mount cannot be accessed,
+	// typically because its fuse daemon died
+	Healthy bool
+}
+
+// Propagation returns the propagation type of the mount as mount(8) names it
+func (m *mountInfo) Propagation() string {
+	var shared, slave bool
+	for _, o := range m.Optional {
+		shared = shared || strings.HasPrefix(o, "shared:")
+		slave = slave || strings.HasPrefix(o, "master:")
+	}
+	switch {
+	case shared && slave:
+		return "shared,slave"
+	case shared:
+		return "shared"
+	case slave:
+		return "slave"
+	}
+	return "private"
+}
+
+// hasOption checks the per-mount options, e.g. ro or nosuid
+func (m *mountInfo) hasOption(option string) bool {
+	for _, o := range m.Options {
+		if o == option {
+			return true
+		}
+	}
+	return false
+}
+
+// inspectMount returns what is mounted on top of path, or nil if path is not a mount point.
+// Unlike a stat based check this also sees bind mounts within the same filesystem,
+// and it does not hang or fail on dead or hung fuse mounts.
+func inspectMount(p string) (*mountInfo, error) {
+	mounts, err := listMounts()
+	if err != nil {
+		return nil, err
+	}
+	return inspectMountIn(mounts, p)
+}
+
+func inspectMountIn(mounts []mountEntry, p string) (*mountInfo, error) {
+	p = filepath.Clean(p)
+	var info *mountInfo
+	for _, m := range mounts {
+		// later entries are mounted on top of earlier ones
+		if m.MountPoint == p {
+			info = &mountInfo{mountEntry: m}
+		}
+	}
+	if info == nil {
+		return nil, nil
+	}
+
+	err := statMountPoint(p)
+	switch {
+	case err == nil:
+		info.Healthy = true
+	case errors.Is(err, errMountHung), mount.IsCorruptedMnt(err):
+		info.Healthy = false
+	default:
+		return nil, fmt.Errorf("cannot access mount point %s: %w", p, err)
+	}
+	return info, nil
+}
+
+// mountStatTimeout is how long a mount point may take to answer stat before it is considered hung
+const mountStatTimeout = 10 * time.Second
+
+// errMountHung is returned for mount points that do not answer stat, typically
+// fuse mounts whose daemon is alive but stuck
+var errMountHung = errors.New("mount point does not respond")
+
+// statMountPoint stats a mount point, giving up after mountStatTimeout. A stat on a
+// hung fuse mount blocks until the daemon answers, which may be never; the goroutine
+// blocked on it is left behind.
+func statMountPoint(p string) error {
+	done := make(chan error, 1)
+	go func() {
+		_, err := os.Stat(p)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(mountStatTimeout):
+		return fmt.Errorf("%w: stat of %s timed out after %s", errMountHung, p, mountStatTimeout)
+	}
+}
+
+// verifyBindMount checks that target is a healthy read-only bind mount of source,
+// carrying the given mount flags, and if slave is set, receiving mounts from source.
+// It returns notMounted, mountBroken, mountHealthy or mountWrong. For the latter
+// the reason explains the mismatch.
+func verifyBindMount(source, target string, slave bool, flags []string) (mountStatus, string, error) {
+	mounts, err := listMounts()
+	if err != nil {
+		return notMounted, "", err
+	}
+
+	info, err := inspectMountIn(mounts, target)
+	if err != nil || info == nil {
+		return notMounted, "", err
+	}
+	if !info.Healthy {
+		return mountBroken, "", nil
+	}
+
+	// a bind mount of source has the same device and starts at the same directory within it
+	src, ok := mountOf(mounts, source)
+	if !ok {
+		return notMounted, "", fmt.Errorf("cannot find the mount %s resides on", source)
+	}
+	rel, err := filepath.Rel(src.MountPoint, filepath.Clean(source))
+	if err != nil {
+		return notMounted, "", err
+	}
+	root := path.Join(src.Root, rel)
+
+	if info.MajorMinor != src.MajorMinor || info.Root != root {
+		return mountWrong, fmt.Sprintf("mounted from %s:%s (%s), expected %s:%s (%s)",
+			info.MajorMinor, info.Root, info.Source, src.MajorMinor, root, source), nil
+	}
+
+	for _, o := range append([]string{"ro"}, flags...) {
+		// strictatime is the absence of the other atime options, the kernel does not list it
+		if o != "strictatime" && !info.hasOption(o) {
+			return mountWrong, fmt.Sprintf("missing mount option %s, has %s", o, strings.Join(info.Options, ",")), nil
+		}
+	}
+
+	if slave && info.Propagation() != "slave" {
+		return mountWrong, fmt.Sprintf("propagation is %s instead of slave", info.Propagation()), nil
+	}
+
+	return mountHealthy, "", nil
+}
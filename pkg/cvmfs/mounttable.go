@@ -0,0 +1,200 @@
+// Copyright CERN.
+//
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cvmfs
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// cvmfs2 is the source name the cvmfs fuse client mounts with
+const cvmfsMountSource = "cvmfs2"
+
+const mountInfoFile = "/proc/self/mountinfo"
+
+// mountEntry is a line of /proc/self/mountinfo, see proc(5)
+type mountEntry struct {
+	ID         int
+	ParentID   int
+	MajorMinor string
+	// Root is the directory within the filesystem that is mounted
+	Root       string
+	MountPoint string
+	Options    []string
+	// Optional holds the propagation fields, e.g. shared:1 or master:2
+	Optional     []string
+	FsType       string
+	Source       string
+	SuperOptions []string
+}
+
+func (m mountEntry) isCVMFS() bool {
+	return m.FsType == "fuse" && m.Source == cvmfsMountSource
+}
+
+// isShared reports whether mounts below this mount propagate to its peers
+func (m mountEntry) isShared() bool {
+	for _, o := range m.Optional {
+		if strings.HasPrefix(o, "shared:") {
+			return true
+		}
+	}
+	return false
+}
+
+// isSlave reports whether this mount receives mounts from its master, but does not propagate back
+func (m mountEntry) isSlave() bool {
+	for _, o := range m.Optional {
+		if strings.HasPrefix(o, "master:") {
+			return true
+		}
+	}
+	return false
+}
+
+// listMounts returns the mount table of this process, in mount order
+func listMounts() ([]mountEntry, error) {
+	f, err := os.Open(mountInfoFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var mounts []mountEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		m, err := parseMountInfoLine(scanner.Text())
+		if err != nil {
+			return nil, err
+		}
+		mounts = append(mounts, m)
+	}
+	return mounts, scanner.Err()
+}
+
+func parseMountInfoLine(line string) (mountEntry, error) {
+	fields := strings.Fields(line)
+	sep := -1
+	for i, f := range fields {
+		if f == "-" {
+			sep = i
+			break
+		}
+	}
+	if sep < 6 || len(fields) < sep+4 {
+		return mountEntry{}, fmt.Errorf("malformed mountinfo line: %s", line)
+	}
+
+	id, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return mountEntry{}, fmt.Errorf("malformed mount ID in mountinfo line: %s", line)
+	}
+	parent, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return mountEntry{}, fmt.Errorf("malformed parent ID in mountinfo line: %s", line)
+	}
+
+	return mountEntry{
+		ID:           id,
+		ParentID:     parent,
+		MajorMinor:   fields[2],
+		Root:         unescapeMountInfo(fields[3]),
+		MountPoint:   unescapeMountInfo(fields[4]),
+		Options:      strings.Split(fields[5], ","),
+		Optional:     fields[6:sep],
+		FsType:       fields[sep+1],
+		Source:       unescapeMountInfo(fields[sep+2]),
+		SuperOptions: strings.Split(fields[sep+3], ","),
+	}, nil
+}
+
+// unescapeMountInfo decodes the octal escapes (e.g. \040 for a space) the kernel uses
+func unescapeMountInfo(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if v, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(v))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// mountOf returns the mount that path resides on, i.e. the topmost mount
+// with the longest mount point that is a prefix of path
+func mountOf(mounts []mountEntry, path string) (mountEntry, bool) {
+	path = filepath.Clean(path)
+	var found mountEntry
+	ok := false
+	for _, m := range mounts {
+		if m.MountPoint != path && m.MountPoint != "/" && !strings.HasPrefix(path, m.MountPoint+"/") {
+			continue
+		}
+		// later entries are mounted on top of earlier ones
+		if !ok || len(m.MountPoint) >= len(found.MountPoint) {
+			found = m
+			ok = true
+		}
+	}
+	return found, ok
+}
+
+// mountFsType returns the filesystem type of the mount on path,
+// or an empty string if path is not a mount point
+func mountFsType(path string) (string, error) {
+	mounts, err := listMounts()
+	if err != nil {
+		return "", err
+	}
+
+	path = filepath.Clean(path)
+	fstype := ""
+	for _, m := range mounts {
+		// the last matching entry is the one on top
+		if m.MountPoint == path {
+			fstype = m.FsType
+		}
+	}
+	return fstype, nil
+}
+
+// hasSubmounts reports whether anything is mounted below path
+func hasSubmounts(path string) (bool, error) {
+	mounts, err := listMounts()
+	if err != nil {
+		return false, err
+	}
+
+	prefix := filepath.Clean(path) + "/"
+	for _, m := range mounts {
+		if strings.HasPrefix(m.MountPoint, prefix) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
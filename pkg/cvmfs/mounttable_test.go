@@ -0,0 +1,161 @@
+// Copyright CERN.
+//
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cvmfs
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseMountInfoLine(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string
+		want    mountEntry
+		wantErr bool
+	}{
+		{
+			name: "cvmfs",
+			line: "1234 28 0:62 / /cvmfs/atlas.cern.ch rw,relatime shared:640 - fuse cvmfs2 ro,user_id=0,group_id=0,default_permissions,allow_other",
+			want: mountEntry{
+				ID: 1234, ParentID: 28, MajorMinor: "0:62", Root: "/", MountPoint: "/cvmfs/atlas.cern.ch",
+				Options: []string{"rw", "relatime"}, Optional: []string{"shared:640"}, FsType: "fuse", Source: "cvmfs2",
+				SuperOptions: []string{"ro", "user_id=0", "group_id=0", "default_permissions", "allow_other"},
+			},
+		},
+		{
+			name: "bind mount of a subdirectory, shared and slave",
+			line: "1300 1200 0:62 /repo/sw /var/lib/kubelet/pods/uid/volumes/kubernetes.io~csi/pv/mount ro,nosuid,nodev shared:700 master:640 - fuse cvmfs2 ro",
+			want: mountEntry{
+				ID: 1300, ParentID: 1200, MajorMinor: "0:62", Root: "/repo/sw",
+				MountPoint: "/var/lib/kubelet/pods/uid/volumes/kubernetes.io~csi/pv/mount",
+				Options:    []string{"ro", "nosuid", "nodev"}, Optional: []string{"shared:700", "master:640"},
+				FsType: "fuse", Source: "cvmfs2", SuperOptions: []string{"ro"},
+			},
+		},
+		{
+			name: "private with escaped space",
+			line: `50 1 8:1 / /mnt/with\040space rw - ext4 /dev/sda1 rw,errors=remount-ro`,
+			want: mountEntry{
+				ID: 50, ParentID: 1, MajorMinor: "8:1", Root: "/", MountPoint: "/mnt/with space",
+				Options: []string{"rw"}, Optional: []string{}, FsType: "ext4", Source: "/dev/sda1",
+				SuperOptions: []string{"rw", "errors=remount-ro"},
+			},
+		},
+		{name: "no separator", line: "50 1 8:1 / /mnt rw ext4 /dev/sda1 rw", wantErr: true},
+		{name: "missing fields after separator", line: "50 1 8:1 / /mnt rw - ext4", wantErr: true},
+		{name: "missing fields before separator", line: "50 1 8:1 / - ext4 /dev/sda1 rw", wantErr: true},
+		{name: "malformed ID", line: "x 1 8:1 / /mnt rw - ext4 /dev/sda1 rw", wantErr: true},
+		{name: "malformed parent ID", line: "50 x 8:1 / /mnt rw - ext4 /dev/sda1 rw", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseMountInfoLine(tt.line)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseMountInfoLine() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseMountInfoLine() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnescapeMountInfo(t *testing.T) {
+	tests := map[string]string{
+		"/plain":               "/plain",
+		`/with\040space`:       "/with space",
+		`/tab\011and\134slash`: "/tab\tand\\slash",
+		`/not\0an\escape`:      `/not\0an\escape`,
+		`/trailing\04`:         `/trailing\04`,
+	}
+	for in, want := range tests {
+		if got := unescapeMountInfo(in); got != want {
+			t.Errorf("unescapeMountInfo(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestMountOf(t *testing.T) {
+	mounts := []mountEntry{
+		{ID: 1, MountPoint: "/"},
+		{ID: 2, MountPoint: "/var/lib/kubelet"},
+		{ID: 3, MountPoint: "/var/lib/kubelet/pods"},
+		{ID: 4, MountPoint: "/var/lib/kubelet/pods"},
+		{ID: 5, MountPoint: "/cvmfs"},
+	}
+	tests := []struct {
+		path string
+		want int
+	}{
+		{path: "/etc/cvmfs", want: 1},
+		{path: "/var/lib/kubelet/plugins", want: 2},
+		{path: "/var/lib/kubelet/pods/uid/volumes", want: 4},
+		{path: "/var/lib/kubelet/pods/", want: 4},
+		{path: "/cvmfs-other", want: 1},
+		{path: "/cvmfs", want: 5},
+	}
+	for _, tt := range tests {
+		got, ok := mountOf(mounts, tt.path)
+		if !ok || got.ID != tt.want {
+			t.Errorf("mountOf(%s) = %d, %t, want %d", tt.path, got.ID, ok, tt.want)
+		}
+	}
+	if _, ok := mountOf(mounts[1:], "/etc"); ok {
+		t.Error("mountOf() found a mount without a root mount")
+	}
+}
+
+func TestMountInfoPropagation(t *testing.T) {
+	tests := []struct {
+		optional []string
+		want     string
+	}{
+		{optional: nil, want: "private"},
+		{optional: []string{"shared:1"}, want: "shared"},
+		{optional: []string{"master:2"}, want: "slave"},
+		{optional: []string{"shared:1", "master:2"}, want: "shared,slave"},
+		{optional: []string{"propagate_from:3", "master:2"}, want: "slave"},
+	}
+	for _, tt := range tests {
+		m := &mountInfo{mountEntry: mountEntry{Optional: tt.optional}}
+		if got := m.Propagation(); got != tt.want {
+			t.Errorf("Propagation() of %q = %s, want %s", tt.optional, got, tt.want)
+		}
+	}
+}
+
+func TestInspectMountIn(t *testing.T) {
+	dir := t.TempDir()
+	mounts := []mountEntry{
+		{ID: 1, MountPoint: "/"},
+		{ID: 2, MountPoint: dir, FsType: "tmpfs"},
+		{ID: 3, MountPoint: dir, FsType: "fuse", Source: cvmfsMountSource},
+	}
+
+	info, err := inspectMountIn(mounts, dir+"/")
+	if err != nil || info == nil {
+		t.Fatalf("inspectMountIn() = %v, %v, want the mount", info, err)
+	}
+	if info.ID != 3 || !info.isCVMFS() || !info.Healthy {
+		t.Errorf("inspectMountIn() = %+v, want the healthy topmost mount", info)
+	}
+
+	if info, err := inspectMountIn(mounts, dir+"/sub"); err != nil || info != nil {
+		t.Errorf("inspectMountIn() below a mount point = %v, %v, want nil", info, err)
+	}
+}
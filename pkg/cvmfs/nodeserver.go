@@ -18,6 +18,7 @@ package cvmfs
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -36,21 +37,18 @@ import (
 var localConfTemplateStr string
 var localConfTemplate = template.Must(template.New("default.local").Parse(localConfTemplateStr))
 
+// BasicSetup prepares the node for mounting repositories, bootstrapping the
+// client configuration from the config repository
 func (d *Driver) BasicSetup() error {
 	log := internal.GetLogger("BasicSetup")
 
-	// we need the cache folder before we can mount anything
-	if err := mkdir(d.config.CacheFolder); err != nil {
-		return fmt.Errorf("cannot create cache root folder %s: %w", d.config.CacheFolder, err)
+	if err := d.prepareNode(); err != nil {
+		return err
 	}
 
 	// The config repository needs to be mounted before any other
 	configPath := CVMFSConfigRepo.getMountPath()
-	if err := mkdir(configPath); err != nil {
-		return fmt.Errorf("cannot create config repository folder %s: %w", CVMFSConfigRepo, err)
-	}
-
-	mounted, err := folderIsMounted(configPath)
+	mounted, err := d.repositoryIsMounted(CVMFSConfigRepo)
 	if err != nil {
 		return fmt.Errorf("cannot check if config folder is mounted: %w", err)
 	}
@@ -64,18 +62,49 @@ func (d *Driver) BasicSetup() error {
 		log.Debug().Str("path", CVMFSLocalConfigFile).Msg("deleting local config file")
 		os.Remove(CVMFSLocalConfigFile)
 
-		if err := MountCVMFS(CVMFSConfigRepo); err != nil {
+		if err := d.mountRepository(CVMFSConfigRepo); err != nil {
 			return err
 		}
 	}
 
-	// create default.local
+	return d.writeLocalConfig()
+}
+
+// offlineSetup prepares the node for volumes that never reach a server, i.e. preloaded
+// ones. It does not need the config repository, so air-gapped nodes can serve them.
+func (d *Driver) offlineSetup() error {
+	if err := d.prepareNode(); err != nil {
+		return err
+	}
+	return d.writeLocalConfig()
+}
+
+// prepareNode creates the cache folder and starts autofs if configured
+func (d *Driver) prepareNode() error {
+	// we need the cache folder before we can mount anything
+	if err := mkdir(d.config.CacheFolder); err != nil {
+		return fmt.Errorf("cannot create cache root folder %s: %w", d.config.CacheFolder, err)
+	}
+
+	if d.config.Automount {
+		if err := d.startAutomount(); err != nil {
+			return fmt.Errorf("cannot set up automounting: %w", err)
+		}
+	}
+	return nil
+}
+
+// writeLocalConfig creates default.local, unless it exists
+func (d *Driver) writeLocalConfig() error {
+	log := internal.GetLogger("writeLocalConfig")
+
 	if _, err := os.Stat(CVMFSLocalConfigFile); os.IsNotExist(err) {
 		log.Debug().Str("path", CVMFSLocalConfigFile).Msg("creating local config file")
 		f, err := os.Create(CVMFSLocalConfigFile)
 		if err != nil {
 			return fmt.Errorf("cannot create local config file %s: %w", CVMFSLocalConfigFile, err)
 		}
+		defer f.Close()
 		var tpl bytes.Buffer
 		wr := io.MultiWriter(f, &tpl)
 		if err := localConfTemplate.Execute(wr, d.config); err != nil {
@@ -88,11 +117,55 @@ func (d *Driver) BasicSetup() error {
 	return nil
 }
 
+// repositoryIsMounted checks if a healthy CVMFS mount of a repository is on its mount path.
+// Anything else found there is unmounted, so the repository can be mounted anew.
+// In automount mode this does not trigger autofs.
+func (d *Driver) repositoryIsMounted(r Repository) (bool, error) {
+	log := internal.GetLogger("repositoryIsMounted").With().Str("repository", string(r)).Logger()
+	to := r.getMountPath()
+	if !d.config.Automount {
+		if err := mkdir(to); err != nil {
+			return false, fmt.Errorf("cannot create CVMFS folder %s: %w", to, err)
+		}
+	}
+
+	info, err := inspectMount(to)
+	if err != nil || info == nil {
+		return false, err
+	}
+
+	if info.Healthy && info.isCVMFS() {
+		return true, nil
+	}
+
+	log.Warn().Str("path", to).Str("fstype", info.FsType).Str("source", info.Source).Bool("healthy", info.Healthy).
+		Msg("unexpected mount on repository path, unmounting")
+	if err := lazyUnmount(to); err != nil {
+		return false, fmt.Errorf("cannot unmount %s: %w", to, err)
+	}
+	return false, nil
+}
+
+// mountRepository mounts a repository on its mount path,
+// either directly or by letting autofs do it
+func (d *Driver) mountRepository(r Repository) error {
+	if d.config.Automount {
+		return triggerAutomount(r)
+	}
+	if d.clientUser != nil {
+		return d.mountUnprivileged(r)
+	}
+	if d.holder != nil {
+		return d.holder.Mount(r)
+	}
+	return MountCVMFS(r)
+}
+
 // NodeStageVolume is called to mount a volume in a 'staging' location, a folder somewhere on the node.
 // This staging folder can be used by many pods simultaneously, since we mount readonly.
 // This driver creates one cvmfs mount per StorageClass, which represents a unique configuration of
 // repository and tag/hash.
-func (d *Driver) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRequest) (*csi.NodeStageVolumeResponse, error) {
+func (d *Driver) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRequest) (_ *csi.NodeStageVolumeResponse, err error) {
 	log := zerolog.Ctx(ctx).With().Str("volumeid", req.GetVolumeId()).Logger()
 	log.Trace().Interface("req", req).Msg("NodeStageVolume")
 
@@ -101,46 +174,91 @@ func (d *Driver) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRe
 		return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("failed to validate NodeStageVolumeRequest: %v", err))
 	}
 
-	if err := d.BasicSetup(); err != nil {
-		return nil, status.Error(codes.Internal, fmt.Sprintf("failed to perform basic setup: %v", err))
+	defer func() {
+		if err != nil {
+			d.recordStageFailure(req.GetVolumeContext(), req.GetStagingTargetPath(), err)
+		}
+	}()
+
+	if err := d.readiness(); err != nil {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
 	}
 
+	// keep the repository from being unmounted as unused before the volume is staged
+	d.mountMu.RLock()
+	defer d.mountMu.RUnlock()
+
 	/*
 	 * get parameters
 	 */
 	log.Trace().Interface("volumecontext", req.GetVolumeContext()).Msg("parsing volumecontext")
-	repository, err := RepositoryFromContext(req.GetVolumeContext())
+	opts, err := VolumeOptionsFromContext(req.GetVolumeContext())
 	if err != nil {
 		return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("cannot parse volume options: %v", err))
 	}
-	to := repository.getMountPath()
-	log = log.With().Str("to", to).Str("repository", string(repository)).Logger()
 
-	/*
-	 * mount cvmfs folder if needed
-	 */
-	if err := mkdir(to); err != nil {
-		return nil, status.Error(codes.Internal, fmt.Sprintf("cannot create CVMFS folder %s: %v", to, err))
+	if opts.Automount {
+		log = log.With().Bool("automount", true).Logger()
+	} else {
+		log = log.With().Str("repository", string(opts.Repository)).Logger()
 	}
 
-	log.Trace().Msg("checking if volume is already mounted")
-	mounted, err := folderIsMounted(to)
+	// preloaded volumes are served without the config repository, e.g. on air-gapped nodes
+	setup := d.BasicSetup
+	if opts.Preload != "" {
+		setup = d.offlineSetup
+	}
+	if err := setup(); err != nil {
+		return nil, status.Error(codes.Internal, fmt.Sprintf("failed to perform basic setup: %v", err))
+	}
+
+	keys, err := publicKeysFromSecrets(req.GetSecrets())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("cannot parse node-stage secrets: %v", err))
+	}
+	if len(keys) != 0 && opts.Automount {
+		return nil, status.Error(codes.InvalidArgument, "automount volumes cannot bring public keys")
+	}
+	creds, err := credentialsFromSecrets(req.GetSecrets())
 	if err != nil {
-		return nil, status.Error(codes.Internal, fmt.Sprintf("cannot probe if folder is already mounted %s: %v", to, err))
+		return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("cannot parse node-stage secrets: %v", err))
+	}
+	if len(creds) != 0 && opts.Protected {
+		return nil, status.Error(codes.InvalidArgument, "protected volumes are mounted with the credentials of their pods, not with node-stage secrets")
 	}
 
-	if mounted {
-		log.Debug().Msg("volume already mounted")
-	} else {
-		log.Debug().Msg("mounting volume")
-		err = MountCVMFS(repository)
-		if err != nil {
-			return nil, status.Error(codes.Internal, fmt.Sprintf("cannot mount volume: %v", err))
-		}
+	// volumes with credentials, private and protected volumes get a client of their own,
+	// which is the only one to use their credentials, keys and configuration
+	isolated := len(creds) != 0 || opts.MountMode == MountPrivate || opts.Protected
+	if isolated {
+		log = log.With().Bool("isolated", true).Logger()
+	}
 
-		log.Info().Msg("volume mounted")
+	sharedKeys := keys
+	if isolated {
+		sharedKeys = nil
+	}
+	if err := d.setupPublicKeys(opts.Repository, sharedKeys); errors.Is(err, errConfigConflict) {
+		return nil, status.Error(codes.FailedPrecondition, fmt.Sprintf("cannot install public keys: %v", err))
+	} else if err != nil {
+		return nil, status.Error(codes.Internal, fmt.Sprintf("cannot install public keys: %v", err))
 	}
 
+	var to string
+	switch {
+	case opts.Protected:
+		// mounted by NodePublishVolume, with the credentials of the pod
+		log.Debug().Msg("protected volume, mounting it when it is published")
+	case isolated:
+		to, err = d.isolatedVolumeSource(log, req.GetVolumeId(), opts, creds, keys)
+	default:
+		to, err = d.volumeSource(log, opts)
+	}
+	if err != nil {
+		return nil, err
+	}
+	log = log.With().Str("to", to).Logger()
+
 	/*
 	 * bind mount to requested folder
 	 */
@@ -152,25 +270,171 @@ func (d *Driver) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRe
 		return nil, status.Error(codes.Internal, fmt.Sprintf("cannot create staging folder %s: %v", stagingTargetPath, err))
 	}
 
-	log.Trace().Msg("checking if staging path is already mounted")
-	mounted, err = folderIsMounted(stagingTargetPath)
+	if !opts.Protected {
+		log.Trace().Msg("checking if staging path is already mounted")
+		if err := ensureBindMount(log, opts, to, stagingTargetPath); err != nil {
+			return nil, status.Error(codes.Internal, fmt.Sprintf("cannot mount staging path %s: %v", stagingTargetPath, err))
+		}
+	}
+
+	err = d.state.Put(&volumeState{
+		VolumeID:          req.GetVolumeId(),
+		Options:           *opts,
+		StagingTargetPath: stagingTargetPath,
+		Source:            to,
+		PublicKeys:        keys,
+		Isolated:          isolated,
+		Credentials:       creds.names(),
+	})
+	if err != nil {
+		return nil, status.Error(codes.Internal, fmt.Sprintf("cannot store volume state: %v", err))
+	}
+
+	return &csi.NodeStageVolumeResponse{}, nil
+}
+
+// volumeSource makes sure the repository of a volume is mounted,
+// and returns the path to bind-mount into the staging path
+func (d *Driver) volumeSource(log zerolog.Logger, opts *VolumeOptions) (string, error) {
+	if opts.Automount {
+		if !d.config.Automount {
+			return "", status.Error(codes.FailedPrecondition, "automount volumes require the driver to run with automounting enabled")
+		}
+		return d.subdirectorySource(AutomountRoot, opts)
+	}
+
+	repository := opts.Repository
+	to := repository.getMountPath()
+
+	if opts.CacheGroup != "" && !d.config.dedicatedCaches() {
+		return "", status.Error(codes.FailedPrecondition, fmt.Sprintf("cacheGroup requires a posix cache, the node uses a %s cache", d.config.CacheType))
+	}
+	cacheBase, dedicated := d.cacheBase(opts)
+	if opts.CacheQuota != 0 && !dedicated {
+		return "", status.Error(codes.InvalidArgument, "cacheQuota requires a cacheGroup or the per-repository cache policy, the shared cache has a single quota")
+	}
+	if err := mkdir(cacheBase); err != nil {
+		return "", status.Error(codes.Internal, fmt.Sprintf("cannot create cache folder %s: %v", cacheBase, err))
+	}
+
+	/*
+	 * mount cvmfs folder if needed
+	 */
+	log.Trace().Msg("checking if volume is already mounted")
+	mounted, err := d.repositoryIsMounted(repository)
+	if err != nil {
+		return "", status.Error(codes.Internal, fmt.Sprintf("cannot probe if folder is already mounted %s: %v", to, err))
+	}
+
+	profile, err := d.siteProfile(opts)
 	if err != nil {
-		return nil, status.Error(codes.Internal, fmt.Sprintf("cannot probe if staging folder is already mounted %s: %v", stagingTargetPath, err))
+		return "", err
+	}
+
+	var preloadedCache string
+	if opts.Preload != "" {
+		preloadedCache, err = d.preloadedCache(opts, mounted)
+		switch {
+		case errors.Is(err, errPreloadDisabled), errors.Is(err, errConfigConflict):
+			return "", status.Error(codes.FailedPrecondition, fmt.Sprintf("cannot use preloaded cache: %v", err))
+		case errors.Is(err, os.ErrNotExist):
+			return "", status.Error(codes.NotFound, fmt.Sprintf("cannot use preloaded cache: %v", err))
+		case err != nil:
+			return "", status.Error(codes.Internal, fmt.Sprintf("cannot use preloaded cache: %v", err))
+		}
+		log.Debug().Str("cache", preloadedCache).Msg("using preloaded cache")
+	}
+
+	if err := d.writeRepositoryConfig(opts, profile, preloadedCache, mounted); errors.Is(err, errConfigConflict) {
+		return "", status.Error(codes.FailedPrecondition, fmt.Sprintf("cannot configure repository: %v", err))
+	} else if err != nil {
+		return "", status.Error(codes.Internal, fmt.Sprintf("cannot configure repository: %v", err))
 	}
 
 	if mounted {
-		log.Debug().Msg("staging path already mounted, skipping")
+		log.Debug().Msg("volume already mounted")
 	} else {
-		log.Debug().Msg("mounting staging path")
-		err = bindMount(to, stagingTargetPath)
+		log.Debug().Msg("mounting volume")
+		err = d.mountRepository(repository)
 		if err != nil {
-			return nil, status.Error(codes.Internal, fmt.Sprintf("cannot mount staging path %s: %v", stagingTargetPath, err))
+			return "", mountErrorStatus(err, "cannot mount volume: %v", err)
 		}
 
 		log.Info().Msg("volume mounted")
 	}
 
-	return &csi.NodeStageVolumeResponse{}, nil
+	if rev, err := d.talk(repository).Revision(); err != nil {
+		log.Debug().Err(err).Msg("cannot query repository revision")
+	} else {
+		log.Debug().Uint64("revision", rev).Msg("repository revision")
+	}
+
+	return d.subdirectorySource(to, opts)
+}
+
+// siteProfile returns the site profile a volume selected, if any
+func (d *Driver) siteProfile(opts *VolumeOptions) (*SiteProfile, error) {
+	if opts.Profile == "" {
+		return nil, nil
+	}
+	profile := d.profiles[opts.Profile]
+	if profile == nil {
+		return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("unknown site profile %s", opts.Profile))
+	}
+	return profile, nil
+}
+
+func (d *Driver) subdirectorySource(root string, opts *VolumeOptions) (string, error) {
+	if opts.Subdirectory == "" {
+		return root, nil
+	}
+
+	from, err := resolveSubdirectory(root, opts.Subdirectory)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", status.Error(codes.NotFound, fmt.Sprintf("subdirectory does not exist: %v", err))
+	} else if err != nil {
+		return "", status.Error(codes.InvalidArgument, fmt.Sprintf("invalid subdirectory: %v", err))
+	}
+	return from, nil
+}
+
+// ensureBindMount bind-mounts from onto to, unless exactly that mount is already there.
+// Broken mounts and mounts of something else are replaced.
+func ensureBindMount(log zerolog.Logger, opts *VolumeOptions, from, to string, flags ...string) error {
+	st, reason, err := verifyBindMount(from, to, opts.Automount, flags)
+	if err != nil {
+		return fmt.Errorf("cannot inspect existing mount: %w", err)
+	}
+
+	switch st {
+	case mountHealthy:
+		log.Debug().Msg("already mounted, skipping")
+		return nil
+	case mountBroken:
+		log.Warn().Msg("existing mount is broken, replacing it")
+	case mountWrong:
+		log.Warn().Str("reason", reason).Msg("existing mount is not the expected one, replacing it")
+	}
+	if st != notMounted {
+		if err := lazyUnmount(to); err != nil {
+			return fmt.Errorf("cannot unmount existing mount: %w", err)
+		}
+	}
+
+	log.Debug().Msg("bind-mounting")
+	if err := bindVolume(opts, from, to, flags...); err != nil {
+		return err
+	}
+	log.Info().Msg("bind-mounted volume")
+	return nil
+}
+
+// bindVolume bind-mounts from onto to in the way the volume options require
+func bindVolume(opts *VolumeOptions, from, to string, flags ...string) error {
+	if opts.Automount {
+		return rbindMount(from, to, flags...)
+	}
+	return bindMount(from, to, flags...)
 }
 
 func (d *Driver) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstageVolumeRequest) (*csi.NodeUnstageVolumeResponse, error) {
@@ -182,12 +446,16 @@ func (d *Driver) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstageVolu
 		return nil, status.Error(codes.InvalidArgument, fmt.Errorf("failed to validate NodeUnstageVolumeRequest: %w", err).Error())
 	}
 
-	if err := Unmount(stagingTargetPath); err != nil {
-		log.Warn().Err(err).Str("path", stagingTargetPath).Msg("failed to unmount")
+	if err := unmountAndRemove(stagingTargetPath); err != nil {
+		return nil, status.Error(codes.Internal, fmt.Sprintf("cannot unmount staging path %s: %v", stagingTargetPath, err))
+	}
+
+	if err := d.removeIsolatedVolume(req.GetVolumeId()); err != nil {
+		return nil, status.Error(codes.Internal, fmt.Sprintf("cannot remove isolated volume: %v", err))
 	}
 
-	if err := os.Remove(stagingTargetPath); err != nil {
-		log.Error().Err(err).Msg("cannot delete staging target path for volume")
+	if err := d.state.Delete(req.GetVolumeId()); err != nil {
+		log.Error().Err(err).Msg("cannot delete volume state")
 	}
 
 	log.Info().Msg("unmounted volume")
@@ -197,12 +465,22 @@ func (d *Driver) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstageVolu
 
 // NodePublishVolume is called after NodeStageVolume and used to bind mount a volume
 // from the staging folder into a pod-specific folder
-func (d *Driver) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
+func (d *Driver) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolumeRequest) (_ *csi.NodePublishVolumeResponse, err error) {
 	log := *zerolog.Ctx(ctx)
 	if err := validateNodePublishVolumeRequest(req); err != nil {
 		return nil, status.Error(codes.InvalidArgument, fmt.Errorf("failed to validate NodePublishVolumeRequest: %w", err).Error())
 	}
 
+	defer func() {
+		if err != nil {
+			d.recordPublishFailure(req.GetVolumeContext(), err)
+		}
+	}()
+
+	if err := d.readiness(); err != nil {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+
 	// Configuration
 
 	targetPath := req.GetTargetPath()
@@ -213,25 +491,46 @@ func (d *Driver) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolu
 		return nil, status.Error(codes.Internal, fmt.Errorf("failed to create mount point for volume: %w", err).Error())
 	}
 
-	// Check if the volume is already mounted
-
-	isMnt, err := folderIsMounted(targetPath)
+	opts, err := VolumeOptionsFromContext(req.GetVolumeContext())
 	if err != nil {
-		return nil, status.Error(codes.Internal, fmt.Errorf("stat failed: %w", err).Error())
+		return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("cannot parse volume options: %v", err))
 	}
 
-	if isMnt {
-		log.Info().Msg("volume is already bind-mounted")
-		return &csi.NodePublishVolumeResponse{}, nil
+	// the volume is always mounted read-only, regardless of req.GetReadonly()
+	flags, err := parseMountFlags(req.GetVolumeCapability().GetMount().GetMountFlags())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("invalid mount flags: %v", err))
+	}
+	log = log.With().Strs("mountflags", flags).Logger()
+
+	// protected volumes are not mounted on the staging path, but for the pod
+	from := req.GetStagingTargetPath()
+	var identity string
+	if opts.Protected {
+		d.isolatedMu.Lock()
+		defer d.isolatedMu.Unlock()
+		if from, identity, err = d.protectedSource(log, string(volId), req.GetVolumeContext(), opts); err != nil {
+			return nil, err
+		}
 	}
 
-	// It's not, bind-mount now
-
-	if err = bindMount(req.GetStagingTargetPath(), targetPath); err != nil {
+	if err = ensureBindMount(log, opts, from, targetPath, flags...); err != nil {
 		return nil, status.Error(codes.Internal, fmt.Errorf("failed to bind-mount volume: %w", err).Error())
 	}
 
-	log.Info().Msg("bind-mounted volume")
+	err = d.state.Update(string(volId), func(v *volumeState) {
+		if v.Targets == nil {
+			v.Targets = map[string]targetState{}
+		}
+		v.Targets[targetPath] = targetState{MountFlags: flags}
+		if opts.Protected {
+			v.Identity = identity
+			v.Credentials = []string{"token"}
+		}
+	})
+	if err != nil {
+		log.Warn().Err(err).Msg("cannot store volume state, target will not be reconciled")
+	}
 
 	return &csi.NodePublishVolumeResponse{}, nil
 }
@@ -245,15 +544,35 @@ func (d *Driver) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpublish
 	volId := volumeID(req.GetVolumeId())
 	log = log.With().Str("volumeid", string(volId)).Str("targetpath", targetPath).Logger()
 
-	if _, err := os.Stat(targetPath); os.IsNotExist(err) {
-		log.Warn().Err(err).Str("path", targetPath).Msg("unpublish called on non-existing directory")
-	} else {
-		if err := Unmount(targetPath); err != nil {
-			log.Warn().Err(err).Str("path", targetPath).Msg("failed to unmount")
+	// the mount of a protected volume goes away with its last target
+	v, err := d.state.Get(string(volId))
+	protected := err == nil && v != nil && v.Options.Protected
+	if protected {
+		d.isolatedMu.Lock()
+		defer d.isolatedMu.Unlock()
+	}
+
+	if err := unmountAndRemove(targetPath); err != nil {
+		return nil, status.Error(codes.Internal, fmt.Sprintf("cannot unmount target path %s: %v", targetPath, err))
+	}
+
+	var unused bool
+	err = d.state.Update(string(volId), func(v *volumeState) {
+		delete(v.Targets, targetPath)
+		if unused = protected && len(v.Targets) == 0; unused {
+			v.Identity = ""
+			v.Credentials = nil
 		}
-		if err := os.Remove(targetPath); err != nil {
-			log.Error().Err(err).Msg("cannot delete target path for volume")
+	})
+	if err != nil && !os.IsNotExist(err) {
+		log.Error().Err(err).Msg("cannot update volume state")
+	}
+
+	if unused {
+		if err := d.removeIsolatedVolume(string(volId)); err != nil {
+			return nil, status.Error(codes.Internal, fmt.Sprintf("cannot unmount protected volume: %v", err))
 		}
+		log.Info().Msg("protected volume unmounted")
 	}
 
 	log.Info().Msg("volume unpublished")
@@ -277,7 +596,8 @@ func (d *Driver) NodeGetCapabilities(ctx context.Context, req *csi.NodeGetCapabi
 
 func (d *Driver) NodeGetInfo(ctx context.Context, req *csi.NodeGetInfoRequest) (*csi.NodeGetInfoResponse, error) {
 	return &csi.NodeGetInfoResponse{
-		NodeId: d.config.NodeID,
+		NodeId:             d.config.NodeID,
+		AccessibleTopology: d.nodeTopology(),
 	}, nil
 }
 
@@ -286,6 +606,10 @@ func validateNodeStageVolumeRequest(req *csi.NodeStageVolumeRequest) error {
 		return fmt.Errorf("volume capability missing in request")
 	}
 
+	if mode := req.GetVolumeCapability().GetAccessMode().GetMode(); !isReadOnlyAccessMode(mode) {
+		return fmt.Errorf("access mode %s is not read-only, CVMFS volumes cannot be written to", mode)
+	}
+
 	if req.GetVolumeId() == "" {
 		return fmt.Errorf("volume ID missing in request")
 	}
@@ -314,6 +638,10 @@ func validateNodePublishVolumeRequest(req *csi.NodePublishVolumeRequest) error {
 		return fmt.Errorf("volume capability missing in request")
 	}
 
+	if mode := req.GetVolumeCapability().GetAccessMode().GetMode(); !isReadOnlyAccessMode(mode) {
+		return fmt.Errorf("access mode %s is not read-only, CVMFS volumes cannot be written to", mode)
+	}
+
 	if req.GetVolumeId() == "" {
 		return fmt.Errorf("volume ID missing in request")
 	}
@@ -0,0 +1,195 @@
+// Copyright CERN.
+//
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cvmfs
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cernops/cvmfs-csi/internal"
+)
+
+// Archives are extracted below CacheFolder, next to the shared cache
+const preloadedCachesDir = "preloaded"
+
+// preloadMarker records which archive a preloaded cache was extracted from
+const preloadMarker = ".preloaded-from"
+
+// errPreloadDisabled is returned for preloaded volumes when the driver has no preload directory
+var errPreloadDisabled = errors.New("preloaded volumes require the driver to run with a preload directory")
+
+// preloadedCache prepares the preloaded cache of a volume, created by cvmfs_preload,
+// and returns the directory to use as alien cache. Directories are used as they are,
+// archives are extracted once per repository.
+func (d *Driver) preloadedCache(opts *VolumeOptions, mounted bool) (string, error) {
+	if d.config.PreloadDir == "" {
+		return "", errPreloadDisabled
+	}
+	root, err := filepath.EvalSymlinks(d.config.PreloadDir)
+	if err != nil {
+		return "", fmt.Errorf("cannot resolve preload directory: %w", err)
+	}
+	src, err := resolveWithin(root, opts.Preload)
+	if err != nil {
+		return "", err
+	}
+	fi, err := os.Stat(src)
+	if err != nil {
+		return "", err
+	}
+
+	r := opts.Repository
+	cache := src
+	if !fi.IsDir() {
+		if cache, err = d.extractPreload(r, src, fi, mounted); err != nil {
+			return "", err
+		}
+	}
+
+	if !mounted {
+		if err := d.installPreloadedChecksum(r, cache); err != nil {
+			return "", err
+		}
+	}
+	return cache, nil
+}
+
+// extractPreload extracts a preload archive for a repository, unless that was done before
+func (d *Driver) extractPreload(r Repository, src string, fi os.FileInfo, mounted bool) (string, error) {
+	log := internal.GetLogger("extractPreload").With().Str("repository", string(r)).Str("archive", src).Logger()
+	target := filepath.Join(d.config.CacheFolder, preloadedCachesDir, string(r))
+
+	// the archive is identified by its path, size and modification time
+	marker := []byte(fmt.Sprintf("%s %d %d\n", src, fi.Size(), fi.ModTime().UnixNano()))
+	current, err := os.ReadFile(filepath.Join(target, preloadMarker))
+	if err == nil && bytes.Equal(current, marker) {
+		return target, nil
+	}
+	if mounted {
+		return "", fmt.Errorf("%w: preloaded from another archive", errConfigConflict)
+	}
+
+	log.Info().Msg("extracting preloaded cache")
+	tmp := target + ".tmp"
+	if err := os.RemoveAll(tmp); err != nil {
+		return "", err
+	}
+	if err := extractTar(src, tmp); err != nil {
+		os.RemoveAll(tmp)
+		return "", fmt.Errorf("cannot extract preload archive %s: %w", src, err)
+	}
+	if err := os.WriteFile(filepath.Join(tmp, preloadMarker), marker, 0644); err != nil {
+		return "", err
+	}
+	if err := os.RemoveAll(target); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp, target); err != nil {
+		return "", err
+	}
+	log.Info().Str("path", target).Msg("preloaded cache extracted")
+	return target, nil
+}
+
+// installPreloadedChecksum copies the root catalog checksum cvmfs_preload stored along
+// with the cache into the workspace, so the client mounts the preloaded revision
+// without asking a server for the current one
+func (d *Driver) installPreloadedChecksum(r Repository, cache string) error {
+	name := "cvmfschecksum." + string(r)
+	b, err := os.ReadFile(filepath.Join(cache, name))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	workspace := filepath.Join(d.config.CacheFolder, "shared")
+	if err := mkdir(workspace); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(workspace, name), b, 0644)
+}
+
+// extractTar extracts a plain or gzip compressed tar archive into dir.
+// Only directories and regular files are extracted, and nothing outside of dir.
+func extractTar(archive, dir string) error {
+	f, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if strings.HasSuffix(archive, ".gz") || strings.HasSuffix(archive, ".tgz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if h.Name == "." || h.Name == "./" {
+			continue
+		}
+		if !isContainedPath(h.Name) {
+			return fmt.Errorf("archive entry %s is outside of the archive", h.Name)
+		}
+		p := filepath.Join(dir, h.Name)
+
+		switch h.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(p, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+				return err
+			}
+			out, err := os.OpenFile(p, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(out, tr)
+			if cerr := out.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright CERN.
+//
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cvmfs
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+var profileNameRegexp = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// SiteProfile describes how repositories are reached from a site,
+// e.g. through a local stratum 1 mirror and squids
+type SiteProfile struct {
+	// ServerURLs are the stratum servers, may contain @fqrn@ (CVMFS_SERVER_URL)
+	ServerURLs []string `json:"serverURLs,omitempty"`
+	// Proxies are the proxy groups, tried in order, each a list of load-balanced
+	// proxies separated by '|' (CVMFS_HTTP_PROXY)
+	Proxies []string `json:"proxies,omitempty"`
+	// FallbackProxies are used when all Proxies fail (CVMFS_FALLBACK_PROXY)
+	FallbackProxies []string `json:"fallbackProxies,omitempty"`
+	// PublicKeys are paths to the keys repositories are signed with (CVMFS_PUBLIC_KEY)
+	PublicKeys []string `json:"publicKeys,omitempty"`
+}
+
+// loadSiteProfiles reads the site profiles from a JSON file mapping names to profiles
+func loadSiteProfiles(path string) (map[string]*SiteProfile, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	profiles := map[string]*SiteProfile{}
+	if err := json.Unmarshal(b, &profiles); err != nil {
+		return nil, fmt.Errorf("cannot parse %s: %w", path, err)
+	}
+	for name, p := range profiles {
+		if !profileNameRegexp.MatchString(name) {
+			return nil, fmt.Errorf("invalid profile name '%s'", name)
+		}
+		if p == nil {
+			return nil, fmt.Errorf("profile %s is empty", name)
+		}
+		if err := p.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid profile %s: %w", name, err)
+		}
+	}
+	return profiles, nil
+}
+
+func (p *SiteProfile) Validate() error {
+	for _, list := range [][]string{p.ServerURLs, p.Proxies, p.FallbackProxies, p.PublicKeys} {
+		for _, v := range list {
+			// the values end up in shell-sourced client configuration
+			if v == "" || strings.ContainsAny(v, "\"'`$\\;\n ") {
+				return fmt.Errorf("invalid value '%s'", v)
+			}
+		}
+	}
+	for _, k := range p.PublicKeys {
+		if _, err := os.Stat(k); err != nil {
+			return fmt.Errorf("cannot access public key: %w", err)
+		}
+	}
+	return nil
+}
+
+// ServerURL returns the CVMFS_SERVER_URL of the profile
+func (p *SiteProfile) ServerURL() string {
+	return strings.Join(p.ServerURLs, ";")
+}
+
+// HTTPProxy returns the CVMFS_HTTP_PROXY of the profile
+func (p *SiteProfile) HTTPProxy() string {
+	return strings.Join(p.Proxies, ";")
+}
+
+// FallbackProxy returns the CVMFS_FALLBACK_PROXY of the profile
+func (p *SiteProfile) FallbackProxy() string {
+	return strings.Join(p.FallbackProxies, ";")
+}
+
+// PublicKey returns the CVMFS_PUBLIC_KEY of the profile
+func (p *SiteProfile) PublicKey() string {
+	return strings.Join(p.PublicKeys, ":")
+}
@@ -0,0 +1,106 @@
+// Copyright CERN.
+//
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cvmfs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cernops/cvmfs-csi/internal"
+)
+
+// propagatedPaths are the directories the node plugin mounts into, these mounts need
+// to propagate back to the host for kubelet and pods to see them. Besides the kubelet
+// directories this is AutomountRoot when it is mounted from the host, e.g. to share it
+// with the mount holder.
+func (d *Driver) propagatedPaths(mounts []mountEntry) []string {
+	paths := []string{
+		filepath.Join(d.config.KubeletDir, "pods"),
+		filepath.Join(d.config.KubeletDir, "plugins"),
+	}
+	for _, m := range mounts {
+		if m.MountPoint == AutomountRoot {
+			paths = append(paths, AutomountRoot)
+			break
+		}
+	}
+	return paths
+}
+
+// helpedPaths are the directories a node plugin that does not mount itself needs to see
+// the mounts of the mount holder in, see mountHelper
+func (d *Driver) helpedPaths() []string {
+	return []string{
+		filepath.Join(d.config.KubeletDir, "pods"),
+		filepath.Join(d.config.KubeletDir, "plugins"),
+		AutomountRoot,
+		d.config.StateDir,
+	}
+}
+
+// checkMountPropagation verifies that every propagated path lives on a shared mount.
+// If the DaemonSet does not use bidirectional mount propagation, volumes would be
+// mounted inside the plugin container only and pods would see empty directories.
+// When the mount holder mounts for the plugin, the plugin only has to see its mounts.
+func (d *Driver) checkMountPropagation() error {
+	log := internal.GetLogger("checkMountPropagation")
+
+	mounts, err := listMounts()
+	if err != nil {
+		return fmt.Errorf("cannot read %s: %w", mountInfoFile, err)
+	}
+
+	paths, propagation := d.propagatedPaths(mounts), "Bidirectional"
+	if mountHelper != nil {
+		paths, propagation = d.helpedPaths(), "HostToContainer"
+	}
+	for _, p := range paths {
+		if _, err := os.Stat(p); err != nil {
+			return fmt.Errorf("%s is not available in the plugin container, it needs to be mounted "+
+				"from the host with 'mountPropagation: %s' (see the cvmfsplugin container "+
+				"in deployments/helm/cvmfs-csi/templates/plugin.yaml): %w", p, propagation, err)
+		}
+
+		m, ok := mountOf(mounts, p)
+		if !ok {
+			return fmt.Errorf("cannot find the mount %s resides on", p)
+		}
+
+		log.Debug().Str("path", p).Str("mountpoint", m.MountPoint).Strs("propagation", m.Optional).Msg("checking mount propagation")
+		if m.isShared() || (mountHelper != nil && m.isSlave()) {
+			continue
+		}
+		current := "private"
+		if len(m.Optional) > 0 {
+			current = strings.Join(m.Optional, " ")
+		}
+		if mountHelper != nil {
+			return fmt.Errorf("%s resides on mount %s with propagation '%s', so volumes mounted by the mount "+
+				"holder would not be visible to the plugin; set 'mountPropagation: HostToContainer' "+
+				"on the volumeMount for %s of the cvmfsplugin container "+
+				"(see deployments/helm/cvmfs-csi/templates/plugin.yaml)", p, m.MountPoint, current, p)
+		}
+		return fmt.Errorf("%s resides on mount %s with propagation '%s' instead of shared, so volumes "+
+			"mounted by the plugin would not be visible to pods; set 'mountPropagation: Bidirectional' "+
+			"on the volumeMount for %s of the cvmfsplugin container "+
+			"(see deployments/helm/cvmfs-csi/templates/plugin.yaml)", p, m.MountPoint, current, p)
+	}
+
+	return nil
+}
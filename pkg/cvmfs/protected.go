@@ -0,0 +1,95 @@
+// Copyright CERN.
+//
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cvmfs
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/rs/zerolog"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Volume context entries kubelet adds for podInfoOnMount and tokenRequests of the CSIDriver
+const (
+	contextServiceAccountName   = "csi.storage.k8s.io/serviceAccount.name"
+	contextServiceAccountTokens = "csi.storage.k8s.io/serviceAccount.tokens"
+)
+
+// serviceAccountToken is a token kubelet requested for the pod a volume is published for
+type serviceAccountToken struct {
+	Token string `json:"token"`
+}
+
+// podIdentity returns the service account of the pod a volume is published for,
+// as namespace/name, along with its token
+func podIdentity(volCtx map[string]string) (string, []byte, error) {
+	namespace, name := volCtx[contextPodNamespace], volCtx[contextServiceAccountName]
+	if namespace == "" || name == "" {
+		return "", nil, fmt.Errorf("pod info missing, the CSIDriver needs podInfoOnMount")
+	}
+
+	s, ok := volCtx[contextServiceAccountTokens]
+	if !ok {
+		return "", nil, fmt.Errorf("service account token missing, the CSIDriver needs tokenRequests")
+	}
+	tokens := map[string]serviceAccountToken{}
+	if err := json.Unmarshal([]byte(s), &tokens); err != nil {
+		return "", nil, fmt.Errorf("cannot parse service account tokens: %w", err)
+	}
+	if len(tokens) != 1 {
+		return "", nil, fmt.Errorf("expected a token for a single audience, got %d", len(tokens))
+	}
+
+	for _, t := range tokens {
+		if t.Token == "" {
+			return "", nil, fmt.Errorf("service account token is empty")
+		}
+		return namespace + "/" + name, []byte(t.Token), nil
+	}
+	return "", nil, nil
+}
+
+// protectedSource mounts a protected volume with the token of the pod it is published for,
+// and returns the path to bind-mount into the target path along with the identity of the pod.
+// All pods using the volume on a node share its mount, so they must run with the same
+// service account. The caller holds isolatedMu.
+func (d *Driver) protectedSource(log zerolog.Logger, volID string, volCtx map[string]string, opts *VolumeOptions) (string, string, error) {
+	identity, token, err := podIdentity(volCtx)
+	if err != nil {
+		return "", "", status.Error(codes.FailedPrecondition, fmt.Sprintf("protected volumes need the identity of their pod: %v", err))
+	}
+	log = log.With().Str("identity", identity).Logger()
+
+	v, err := d.state.Get(volID)
+	if err != nil {
+		return "", "", status.Error(codes.Internal, fmt.Sprintf("cannot read volume state: %v", err))
+	}
+	if v == nil {
+		return "", "", status.Error(codes.FailedPrecondition, "protected volume is not staged")
+	}
+	if v.Identity != "" && v.Identity != identity && len(v.Targets) != 0 {
+		return "", "", status.Error(codes.PermissionDenied, fmt.Sprintf("volume is mounted for service account %s on this node, not for %s", v.Identity, identity))
+	}
+
+	source, err := d.isolatedVolumeSource(log, volID, opts, credentials{"token": token}, v.PublicKeys)
+	if err != nil {
+		return "", "", err
+	}
+	return source, identity, nil
+}
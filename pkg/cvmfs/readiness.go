@@ -0,0 +1,101 @@
+// Copyright CERN.
+//
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cvmfs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/cernops/cvmfs-csi/internal"
+)
+
+// readinessSocket is the unix socket in the state directory the node plugin answers
+// readiness checks on. The ready admin subcommand asks it, so the readiness probe
+// sees what the running plugin found with its own configuration.
+const readinessSocket = "ready.sock"
+
+const readinessTimeout = 5 * time.Second
+
+// serveReadiness answers readiness checks on the readiness socket. It never returns.
+func (d *Driver) serveReadiness() {
+	socket := filepath.Join(d.config.StateDir, readinessSocket)
+	log := internal.GetLogger("readiness").With().Str("socket", socket).Logger()
+
+	if err := os.Remove(socket); err != nil && !os.IsNotExist(err) {
+		log.Error().Err(err).Msg("cannot remove stale readiness socket")
+		return
+	}
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		log.Error().Err(err).Msg("cannot listen on readiness socket")
+		return
+	}
+	if err := os.Chmod(socket, 0600); err != nil {
+		log.Error().Err(err).Msg("cannot restrict readiness socket")
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ready", func(w http.ResponseWriter, r *http.Request) {
+		if err := d.readiness(); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		if atomic.LoadInt32(&d.reconciled) == 0 {
+			http.Error(w, "restoring the mounts of the previous plugin instance", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ready")
+	})
+
+	err = http.Serve(l, mux)
+	log.Error().Err(err).Msg("readiness socket stopped")
+}
+
+// queryReadiness asks the node plugin using stateDir whether it can serve volumes
+func queryReadiness(stateDir string) error {
+	socket := filepath.Join(stateDir, readinessSocket)
+	client := &http.Client{
+		Timeout: readinessTimeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		},
+	}
+
+	// the host is ignored, requests always go to the socket
+	resp, err := client.Get("http://node-plugin/ready")
+	if err != nil {
+		return fmt.Errorf("cannot reach the node plugin: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%s", strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
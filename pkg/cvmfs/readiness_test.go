@@ -0,0 +1,57 @@
+// Copyright CERN.
+//
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cvmfs
+
+import (
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestReadiness(t *testing.T) {
+	dir := t.TempDir()
+	if err := queryReadiness(dir); err == nil {
+		t.Fatal("queryReadiness() without a node plugin succeeded")
+	}
+
+	d := &Driver{config: DriverConfig{StateDir: dir}}
+	go d.serveReadiness()
+
+	// wait for the socket
+	var err error
+	for i := 0; i < 50; i++ {
+		if err = queryReadiness(dir); err != nil && strings.Contains(err.Error(), "restoring") {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err == nil || !strings.Contains(err.Error(), "restoring") {
+		t.Fatalf("queryReadiness() before reconcile = %v", err)
+	}
+
+	atomic.StoreInt32(&d.reconciled, 1)
+	if err := queryReadiness(dir); err != nil {
+		t.Errorf("queryReadiness() = %v, want ready", err)
+	}
+
+	d.setNotReady(errors.New("mount propagation is misconfigured"))
+	if err := queryReadiness(dir); err == nil || err.Error() != "mount propagation is misconfigured" {
+		t.Errorf("queryReadiness() = %v, want the reason the node is not ready", err)
+	}
+}
@@ -0,0 +1,286 @@
+// Copyright CERN.
+//
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cvmfs
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cernops/cvmfs-csi/internal"
+	"github.com/rs/zerolog"
+)
+
+// kubeletVolumeData is the vol_data.json kubelet keeps next to every
+// staging and publish path of a CSI volume
+type kubeletVolumeData struct {
+	DriverName   string `json:"driverName"`
+	VolumeHandle string `json:"volumeHandle"`
+	// SpecVolID is the name of the PersistentVolume
+	SpecVolID string `json:"specVolID"`
+}
+
+func readKubeletVolumeData(mountpoint string) (*kubeletVolumeData, error) {
+	b, err := os.ReadFile(filepath.Join(filepath.Dir(mountpoint), "vol_data.json"))
+	if err != nil {
+		return nil, err
+	}
+	data := &kubeletVolumeData{}
+	return data, json.Unmarshal(b, data)
+}
+
+// reconcile runs once at startup. It repairs the staging and publish mounts of all
+// volumes this node server knows about, which break when the fuse daemons backing
+// them die with a previous plugin instance, and removes mounts kubelet forgot about.
+func (d *Driver) reconcile() error {
+	log := internal.GetLogger("reconcile")
+
+	volumes, err := d.state.List()
+	if err != nil {
+		return fmt.Errorf("cannot list volume state: %w", err)
+	}
+
+	if len(volumes) > 0 {
+		if err := d.BasicSetup(); err != nil {
+			log.Error().Err(err).Msg("basic setup failed, only preloaded volumes may be restored")
+			if err := d.offlineSetup(); err != nil {
+				log.Error().Err(err).Msg("offline setup failed, volumes may not be restored")
+			}
+		}
+	}
+
+	for _, v := range volumes {
+		vlog := log.With().Str("volumeid", v.VolumeID).Logger()
+		if err := d.reconcileVolume(vlog, v); err != nil {
+			vlog.Error().Err(err).Msg("cannot reconcile volume")
+		}
+	}
+
+	return d.removeOrphanMounts(log, knownPaths(volumes))
+}
+
+func (d *Driver) reconcileVolume(log zerolog.Logger, v *volumeState) error {
+	staging := v.StagingTargetPath
+	// a hung mount does not answer stat, it is left to reconcileMount to remount it
+	if err := statMountPoint(staging); os.IsNotExist(err) {
+		log.Info().Str("stagingpath", staging).Msg("staging path is gone, forgetting volume")
+		return d.forgetVolume(v.VolumeID)
+	}
+
+	// protected volumes have nothing mounted on the staging path
+	if !v.Options.Protected {
+		err := reconcileMount(log, staging, func() error {
+			source, err := d.reconcileSource(log, v)
+			if err != nil {
+				return err
+			}
+			return bindVolume(&v.Options, source, staging)
+		})
+		if err != nil {
+			return fmt.Errorf("cannot restore staging path %s: %w", staging, err)
+		}
+	}
+
+	for target, t := range v.Targets {
+		if err := statMountPoint(target); os.IsNotExist(err) {
+			log.Info().Str("targetpath", target).Msg("target path is gone, forgetting it")
+			err := d.state.Update(v.VolumeID, func(v *volumeState) {
+				delete(v.Targets, target)
+			})
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		flags := t.MountFlags
+		err := reconcileMount(log, target, func() error {
+			source := staging
+			if v.Options.Protected {
+				var err error
+				if source, err = d.reconcileSource(log, v); err != nil {
+					return err
+				}
+			}
+			return bindVolume(&v.Options, source, target, flags...)
+		})
+		if err != nil {
+			return fmt.Errorf("cannot restore target path %s: %w", target, err)
+		}
+	}
+
+	return nil
+}
+
+// forgetVolume removes what is left of a volume kubelet unstaged without telling us
+func (d *Driver) forgetVolume(volID string) error {
+	if err := d.removeIsolatedVolume(volID); err != nil {
+		return err
+	}
+	return d.state.Delete(volID)
+}
+
+// reconcileSource mounts the repository of a volume again, like NodeStageVolume did
+func (d *Driver) reconcileSource(log zerolog.Logger, v *volumeState) (string, error) {
+	if !v.Isolated {
+		if err := d.setupPublicKeys(v.Options.Repository, v.PublicKeys); err != nil {
+			return "", err
+		}
+		return d.volumeSource(log, &v.Options)
+	}
+
+	if err := d.setupPublicKeys(v.Options.Repository, nil); err != nil {
+		return "", err
+	}
+	creds, err := d.isolatedVolume(v.VolumeID).readCredentials(v.Credentials)
+	if err != nil {
+		return "", fmt.Errorf("cannot restore credentials: %w", err)
+	}
+	return d.isolatedVolumeSource(log, v.VolumeID, &v.Options, creds, v.PublicKeys)
+}
+
+// reconcileMount leaves healthy mounts alone, and (re)mounts broken or missing ones
+func reconcileMount(log zerolog.Logger, path string, remount func() error) error {
+	log = log.With().Str("path", path).Logger()
+	st, err := probeMount(path)
+	if err != nil {
+		return err
+	}
+
+	switch st {
+	case mountHealthy:
+		log.Debug().Msg("mount is healthy")
+		return nil
+	case mountBroken:
+		log.Warn().Msg("mount is broken, remounting")
+		if err := lazyUnmount(path); err != nil {
+			return fmt.Errorf("cannot unmount broken mount: %w", err)
+		}
+	case notMounted:
+		log.Warn().Msg("mount is missing, remounting")
+	}
+
+	if err := remount(); err != nil {
+		return err
+	}
+	log.Info().Msg("mount restored")
+	return nil
+}
+
+// knownPaths returns the staging and target paths of volumes
+func knownPaths(volumes []*volumeState) map[string]bool {
+	known := map[string]bool{}
+	for _, v := range volumes {
+		known[filepath.Clean(v.StagingTargetPath)] = true
+		for t := range v.Targets {
+			known[filepath.Clean(t)] = true
+		}
+	}
+	return known
+}
+
+// orphanMount is a CVMFS mount below the kubelet directory this node server does not track
+type orphanMount struct {
+	Path string
+	// VolumeID is the volume kubelet knows the mount as, empty if kubelet forgot about it
+	VolumeID string
+	Broken   bool
+}
+
+// orphanMounts returns the CVMFS mounts below the kubelet directory that are not
+// tracked by this node server, and that are not mounts of other drivers
+func (d *Driver) orphanMounts(log zerolog.Logger, known map[string]bool) ([]orphanMount, error) {
+	mounts, err := listMounts()
+	if err != nil {
+		return nil, fmt.Errorf("cannot list mounts: %w", err)
+	}
+
+	prefix := filepath.Clean(d.config.KubeletDir) + "/"
+	seen := map[string]bool{}
+	var orphans []orphanMount
+	for _, m := range mounts {
+		mp := m.MountPoint
+		if !m.isCVMFS() || !strings.HasPrefix(mp, prefix) || seen[mp] || isKnownMount(known, mp) {
+			continue
+		}
+		seen[mp] = true
+
+		o := orphanMount{Path: mp}
+		data, err := readKubeletVolumeData(mp)
+		if err == nil && data.DriverName != d.config.DriverName {
+			continue
+		}
+		if err == nil {
+			o.VolumeID = data.VolumeHandle
+		}
+		st, err := probeMount(mp)
+		if err != nil {
+			log.Error().Err(err).Str("path", mp).Msg("cannot probe untracked mount")
+			// without kubelet knowing about it the mount is removed anyway
+			if o.VolumeID != "" {
+				continue
+			}
+		}
+		o.Broken = st == mountBroken
+		orphans = append(orphans, o)
+	}
+	return orphans, nil
+}
+
+// removable reports whether an orphan mount can be removed. Untracked mounts kubelet
+// still knows about are only removed when broken, since we cannot tell how to restore them.
+func (o orphanMount) removable() bool {
+	return o.VolumeID == "" || o.Broken
+}
+
+// removeOrphanMounts unmounts the orphan mounts that can be removed
+func (d *Driver) removeOrphanMounts(log zerolog.Logger, known map[string]bool) error {
+	orphans, err := d.orphanMounts(log, known)
+	if err != nil {
+		return err
+	}
+
+	for _, o := range orphans {
+		mlog := log.With().Str("path", o.Path).Logger()
+		switch {
+		case !o.removable():
+			mlog.Warn().Str("volumeid", o.VolumeID).Msg("leaving healthy untracked mount alone")
+			continue
+		case o.VolumeID != "":
+			mlog.Warn().Str("volumeid", o.VolumeID).Msg("removing broken untracked mount")
+		default:
+			mlog.Warn().Msg("removing mount unknown to kubelet")
+		}
+
+		if err := lazyUnmount(o.Path); err != nil {
+			mlog.Error().Err(err).Msg("cannot remove orphan mount")
+		}
+	}
+	return nil
+}
+
+// isKnownMount checks if mountpoint is, or lies below (e.g. for automount volumes), a known path
+func isKnownMount(known map[string]bool, mountpoint string) bool {
+	for p := mountpoint; p != "/" && p != "."; p = filepath.Dir(p) {
+		if known[p] {
+			return true
+		}
+	}
+	return false
+}
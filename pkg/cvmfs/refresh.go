@@ -0,0 +1,129 @@
+// Copyright CERN.
+//
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cvmfs
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cernops/cvmfs-csi/internal"
+)
+
+// refreshTick is how often the refresher checks if a repository is due
+const refreshTick = 30 * time.Second
+
+// runRefresher periodically refreshes the repositories of staged volumes
+// with the ttl refresh policy and a refresh interval. It never returns.
+func (d *Driver) runRefresher() {
+	log := internal.GetLogger("refresher")
+	last := map[Repository]time.Time{}
+
+	ticker := time.NewTicker(refreshTick)
+	defer ticker.Stop()
+	for range ticker.C {
+		due, err := d.dueRepositories(last, time.Now())
+		if err != nil {
+			log.Error().Err(err).Msg("cannot determine repositories to refresh")
+			d.lastErrors.add("refresher", "", err)
+			continue
+		}
+
+		for _, r := range due {
+			last[r] = time.Now()
+			if err := d.refreshRepository(r); err != nil {
+				log.Error().Err(err).Str("repository", string(r)).Msg("refresh failed")
+				d.lastErrors.add("refresher", "", fmt.Errorf("refreshing %s: %w", r, err))
+			}
+		}
+	}
+}
+
+// dueRepositories returns the repositories whose shortest refresh interval has passed.
+// Repositories with a pinned volume are never refreshed.
+func (d *Driver) dueRepositories(last map[Repository]time.Time, now time.Time) ([]Repository, error) {
+	volumes, err := d.state.List()
+	if err != nil {
+		return nil, err
+	}
+
+	intervals := map[Repository]time.Duration{}
+	pinned := map[Repository]bool{}
+	for _, v := range volumes {
+		o := v.Options
+		// isolated clients are not reachable through the control socket of the repository
+		if o.Automount || v.Isolated {
+			continue
+		}
+		if o.RefreshPolicy == RefreshPinned {
+			pinned[o.Repository] = true
+		}
+		if o.RefreshPolicy != RefreshTTL || o.RefreshInterval == 0 {
+			continue
+		}
+		if i, ok := intervals[o.Repository]; !ok || o.RefreshInterval < i {
+			intervals[o.Repository] = o.RefreshInterval
+		}
+	}
+
+	var due []Repository
+	for r, i := range intervals {
+		if !pinned[r] && now.Sub(last[r]) >= i {
+			due = append(due, r)
+		}
+	}
+	return due, nil
+}
+
+// refreshRepository makes the client of a head-following repository check
+// for a new revision and switch to it right away
+func (d *Driver) refreshRepository(r Repository) error {
+	_, _, err := refreshClient(r, d.talk(r))
+	return err
+}
+
+// refreshClient makes a client of repository r check for a new revision and switch
+// to it right away. It returns the revisions before and after.
+func refreshClient(r Repository, t *talkClient) (uint64, uint64, error) {
+	log := internal.GetLogger("refreshClient").With().Str("repository", string(r)).Str("socket", t.socket).Logger()
+
+	before, err := t.Revision()
+	if err != nil {
+		return 0, 0, fmt.Errorf("cannot query revision: %w", err)
+	}
+
+	answer, err := t.Command("remount sync")
+	if err != nil {
+		return before, before, fmt.Errorf("cannot remount: %w", err)
+	}
+	answer = strings.TrimSpace(answer)
+	if strings.HasPrefix(answer, "Failed") {
+		return before, before, fmt.Errorf("remount failed: %s", answer)
+	}
+
+	after, err := t.Revision()
+	if err != nil {
+		return before, before, fmt.Errorf("cannot query revision: %w", err)
+	}
+
+	if after != before {
+		log.Info().Uint64("from", before).Uint64("to", after).Msg("repository updated")
+	} else {
+		log.Debug().Uint64("revision", after).Str("answer", answer).Msg("repository up to date")
+	}
+	return before, after, nil
+}
@@ -3,8 +3,11 @@ package cvmfs
 import (
 	"fmt"
 	"path"
+	"regexp"
 )
 
+var repositoryRegexp = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
 type Repository string
 
 func RepositoryFrom(s string) (Repository, error) {
@@ -21,9 +24,17 @@ func (r *Repository) Validate() error {
 	if string(*r) == "" {
 		return fmt.Errorf("empty repository parameter")
 	}
+	if !repositoryRegexp.MatchString(string(*r)) {
+		return fmt.Errorf("invalid repository name '%s'", string(*r))
+	}
 	return nil
 }
 
 func (r *Repository) getMountPath() string {
 	return path.Join("/cvmfs", string(*r))
 }
+
+// getConfigPath returns the repository specific client configuration managed by the driver
+func (r *Repository) getConfigPath() string {
+	return path.Join(CVMFSConfigDir, string(*r)+".local")
+}
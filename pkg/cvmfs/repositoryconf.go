@@ -0,0 +1,109 @@
+// Copyright CERN.
+//
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cvmfs
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/template"
+
+	_ "embed"
+
+	"github.com/cernops/cvmfs-csi/internal"
+	"github.com/rs/zerolog"
+)
+
+//go:embed repositoryconf.go.tpl
+var repositoryConfTemplateStr string
+var repositoryConfTemplate = template.Must(template.New("repository.local").Parse(repositoryConfTemplateStr))
+
+// errConfigConflict is returned when a repository is already mounted with another configuration
+var errConfigConflict = fmt.Errorf("repository is already mounted with a different configuration")
+
+// writeRepositoryConfig generates the client configuration a volume needs for its repository.
+// Since all volumes share the mount of a repository, the configuration of a mounted
+// repository cannot be changed, and volumes asking for another one are refused.
+// preloadedCache is the alien cache of a preloaded volume, if any.
+func (d *Driver) writeRepositoryConfig(opts *VolumeOptions, profile *SiteProfile, preloadedCache string, mounted bool) error {
+	r := opts.Repository
+	p := r.getConfigPath()
+	log := internal.GetLogger("writeRepositoryConfig").With().Str("repository", string(r)).Str("path", p).Logger()
+
+	// only dedicated caches are configured per repository, the shared one in default.local
+	cacheBase, dedicated := d.cacheBase(opts)
+	if !dedicated {
+		cacheBase = ""
+	}
+
+	conf, err := d.renderRepositoryConfig(opts, profile, preloadedCache, cacheBase)
+	if err != nil {
+		return err
+	}
+	return d.installRepositoryConfig(log, r, p, conf, mounted)
+}
+
+// installRepositoryConfig writes the configuration conf of repository r to p, unless it is
+// there already. If r is mounted, a different configuration is refused with errConfigConflict.
+// Repositories mounted without a configuration file, e.g. by autofs or an older version of
+// the driver, run with the configuration of volumes without options.
+func (d *Driver) installRepositoryConfig(log zerolog.Logger, r Repository, p string, conf []byte, mounted bool) error {
+	current, err := os.ReadFile(p)
+	if os.IsNotExist(err) && mounted {
+		if current, err = d.renderRepositoryConfig(&VolumeOptions{Repository: r}, nil, "", ""); err != nil {
+			return err
+		}
+	} else if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("cannot read repository config %s: %w", p, err)
+	}
+	if bytes.Equal(current, conf) {
+		return nil
+	}
+	if mounted {
+		log.Debug().Bytes("current", current).Bytes("requested", conf).Msg("configuration conflict")
+		return errConfigConflict
+	}
+
+	dir := filepath.Dir(p)
+	if err := mkdir(dir); err != nil {
+		return fmt.Errorf("cannot create config folder %s: %w", dir, err)
+	}
+	if err := os.WriteFile(p, conf, 0644); err != nil {
+		return fmt.Errorf("cannot write repository config %s: %w", p, err)
+	}
+	log.Debug().Bytes("content", conf).Msg("repository config written")
+	return nil
+}
+
+// renderRepositoryConfig generates the repository specific client configuration of a volume.
+// cacheBase is left empty unless the repository has a cache of its own.
+func (d *Driver) renderRepositoryConfig(opts *VolumeOptions, profile *SiteProfile, preloadedCache, cacheBase string) ([]byte, error) {
+	var tpl bytes.Buffer
+	data := struct {
+		DriverName     string
+		CacheBase      string
+		PreloadedCache string
+		Workspace      string
+		SiteProfile    *SiteProfile
+		*VolumeOptions
+	}{d.config.DriverName, cacheBase, preloadedCache, filepath.Join(d.config.CacheFolder, "shared"), profile, opts}
+	if err := repositoryConfTemplate.Execute(&tpl, data); err != nil {
+		return nil, fmt.Errorf("cannot generate repository config: %w", err)
+	}
+	return tpl.Bytes(), nil
+}
@@ -0,0 +1,79 @@
+// Copyright CERN.
+//
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cvmfs
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestInstallRepositoryConfig(t *testing.T) {
+	d := &Driver{config: DriverConfig{DriverName: "cvmfs.csi.cern.ch", CacheFolder: "/var/cache/cvmfs"}}
+	render := func(opts *VolumeOptions) []byte {
+		opts.Repository = "atlas.cern.ch"
+		conf, err := d.renderRepositoryConfig(opts, nil, "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return conf
+	}
+	defaults := render(&VolumeOptions{RefreshPolicy: RefreshTTL})
+	pinned := render(&VolumeOptions{Tag: "trunk", RefreshPolicy: RefreshPinned})
+
+	tests := []struct {
+		name    string
+		current []byte // nil when there is no configuration file
+		conf    []byte
+		mounted bool
+		wantErr error
+	}{
+		{name: "not mounted without file", conf: pinned},
+		{name: "not mounted with other file", current: defaults, conf: pinned},
+		{name: "mounted with same file", current: pinned, conf: pinned, mounted: true},
+		{name: "mounted with other file", current: defaults, conf: pinned, mounted: true, wantErr: errConfigConflict},
+		{name: "mounted without file, default options", conf: defaults, mounted: true},
+		{name: "mounted without file, other options", conf: pinned, mounted: true, wantErr: errConfigConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := filepath.Join(t.TempDir(), "config.d", "atlas.cern.ch.local")
+			if tt.current != nil {
+				if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(p, tt.current, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err := d.installRepositoryConfig(zerolog.Nop(), "atlas.cern.ch", p, tt.conf, tt.mounted)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("installRepositoryConfig() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil || tt.mounted {
+				return
+			}
+			if b, err := os.ReadFile(p); err != nil || string(b) != string(tt.conf) {
+				t.Errorf("configuration file = %q, %v, want %q", b, err, tt.conf)
+			}
+		})
+	}
+}
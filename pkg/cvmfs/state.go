@@ -0,0 +1,248 @@
+// Copyright CERN.
+//
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cvmfs
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/cernops/cvmfs-csi/internal"
+)
+
+// volumeState is what the node server remembers about a staged volume,
+// so mounts can be reconciled after the plugin restarts
+type volumeState struct {
+	VolumeID          string        `json:"volumeID"`
+	Options           VolumeOptions `json:"options"`
+	StagingTargetPath string        `json:"stagingTargetPath"`
+	// Source is the path that was bind-mounted onto StagingTargetPath
+	Source  string                 `json:"source"`
+	Targets map[string]targetState `json:"targets,omitempty"`
+	// PublicKeys came with the node-stage secrets, and are installed again after restarts
+	PublicKeys publicKeys `json:"publicKeys,omitempty"`
+	// Isolated volumes have a client of their own, see isolatedVolumeSource
+	Isolated bool `json:"isolated,omitempty"`
+	// Credentials are the names of the credentials of an isolated volume.
+	// Their content is kept on a tmpfs in the directory of the volume, see writeCredentials,
+	// and does not survive a reboot of the node.
+	Credentials []string `json:"credentials,omitempty"`
+	// Identity is the service account, namespace/name, a protected volume is mounted for
+	Identity string `json:"identity,omitempty"`
+}
+
+// targetState describes a single NodePublishVolume of a staged volume
+type targetState struct {
+	MountFlags []string `json:"mountFlags,omitempty"`
+}
+
+// stateLockFile is locked in the state directory while it is accessed
+const stateLockFile = ".lock"
+
+// stateStore persists volumeStates as one JSON file per volume in a directory
+type stateStore struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// lock serializes access to the state directory, within this process and with other
+// processes, e.g. the admin subcommands run next to the driver. It returns the unlock function.
+func (s *stateStore) lock() (func(), error) {
+	s.mu.Lock()
+	f, err := os.OpenFile(filepath.Join(s.dir, stateLockFile), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("cannot open state lock: %w", err)
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		s.mu.Unlock()
+		return nil, fmt.Errorf("cannot lock state directory: %w", err)
+	}
+	return func() {
+		// closing the file releases the lock
+		f.Close()
+		s.mu.Unlock()
+	}, nil
+}
+
+func newStateStore(dir string) (*stateStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("cannot create state directory %s: %w", dir, err)
+	}
+	return &stateStore{dir: dir}, nil
+}
+
+func (s *stateStore) path(volID string) string {
+	return filepath.Join(s.dir, url.PathEscape(volID)+".json")
+}
+
+func (s *stateStore) read(volID string) (*volumeState, error) {
+	b, err := os.ReadFile(s.path(volID))
+	if err != nil {
+		return nil, err
+	}
+	v := &volumeState{}
+	if err := json.Unmarshal(b, v); err != nil {
+		return nil, fmt.Errorf("corrupt state for volume %s: %w", volID, err)
+	}
+	return v, nil
+}
+
+func (s *stateStore) write(v *volumeState) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	tmp := s.path(v.VolumeID) + ".tmp"
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path(v.VolumeID))
+}
+
+// Get returns the state of a volume, or nil if it is unknown
+func (s *stateStore) Get(volID string) (*volumeState, error) {
+	unlock, err := s.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	v, err := s.read(volID)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return v, err
+}
+
+// Put stores the state of a volume, replacing what was there before
+func (s *stateStore) Put(v *volumeState) error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return s.write(v)
+}
+
+// Update modifies the state of a known volume
+func (s *stateStore) Update(volID string, f func(v *volumeState)) error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	v, err := s.read(volID)
+	if err != nil {
+		return err
+	}
+	f(v)
+	return s.write(v)
+}
+
+// Delete forgets about a volume
+func (s *stateStore) Delete(volID string) error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return s.remove(volID)
+}
+
+// DeleteIf forgets about a volume if cond holds for its current state,
+// and reports whether it did
+func (s *stateStore) DeleteIf(volID string, cond func(v *volumeState) bool) (bool, error) {
+	unlock, err := s.lock()
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+	v, err := s.read(volID)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if !cond(v) {
+		return false, nil
+	}
+	return true, s.remove(volID)
+}
+
+// Stat returns the file info of the state of a volume. Every write replaces the file,
+// so it tells whether the state was written since, see DeleteUnchanged.
+func (s *stateStore) Stat(volID string) (os.FileInfo, error) {
+	return os.Stat(s.path(volID))
+}
+
+// DeleteUnchanged forgets about a volume unless its state was written since seen was
+// taken with Stat, and reports whether it did. Unlike a condition on the state itself
+// this can be decided before taking the lock, without probing anything while holding it.
+func (s *stateStore) DeleteUnchanged(volID string, seen os.FileInfo) (bool, error) {
+	return s.DeleteIf(volID, func(*volumeState) bool {
+		fi, err := s.Stat(volID)
+		return err == nil && os.SameFile(fi, seen) && fi.ModTime().Equal(seen.ModTime()) && fi.Size() == seen.Size()
+	})
+}
+
+func (s *stateStore) remove(volID string) error {
+	err := os.Remove(s.path(volID))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// List returns the states of all known volumes. Volumes whose state cannot be read are left out.
+func (s *stateStore) List() ([]*volumeState, error) {
+	unlock, err := s.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	log := internal.GetLogger("stateStore")
+	var volumes []*volumeState
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		volID, err := url.PathUnescape(strings.TrimSuffix(name, ".json"))
+		if err != nil {
+			continue
+		}
+		v, err := s.read(volID)
+		if err != nil {
+			// one unreadable volume must not keep the others from being reconciled
+			log.Error().Err(err).Str("volumeid", volID).Msg("skipping volume state")
+			continue
+		}
+		volumes = append(volumes, v)
+	}
+	return volumes, nil
+}
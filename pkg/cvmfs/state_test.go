@@ -0,0 +1,82 @@
+// Copyright CERN.
+//
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cvmfs
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestStateStoreDeleteIf(t *testing.T) {
+	s, err := newStateStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put(&volumeState{VolumeID: "vol-1", StagingTargetPath: "/staging/vol-1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	deleted, err := s.DeleteIf("vol-1", func(v *volumeState) bool { return v.StagingTargetPath == "/elsewhere" })
+	if err != nil || deleted {
+		t.Fatalf("DeleteIf() = %t, %v, want the volume kept", deleted, err)
+	}
+	deleted, err = s.DeleteIf("vol-1", func(v *volumeState) bool { return v.StagingTargetPath == "/staging/vol-1" })
+	if err != nil || !deleted {
+		t.Fatalf("DeleteIf() = %t, %v, want the volume deleted", deleted, err)
+	}
+	deleted, err = s.DeleteIf("vol-1", func(*volumeState) bool { return true })
+	if err != nil || deleted {
+		t.Fatalf("DeleteIf() of an unknown volume = %t, %v", deleted, err)
+	}
+}
+
+func TestStateStoreDeleteUnchanged(t *testing.T) {
+	s, err := newStateStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := &volumeState{VolumeID: "vol-1", StagingTargetPath: "/staging/vol-1"}
+	if err := s.Put(v); err != nil {
+		t.Fatal(err)
+	}
+	seen, err := s.Stat("vol-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// staged again meanwhile, with the very same state
+	time.Sleep(10 * time.Millisecond)
+	if err := s.Put(v); err != nil {
+		t.Fatal(err)
+	}
+	deleted, err := s.DeleteUnchanged("vol-1", seen)
+	if err != nil || deleted {
+		t.Fatalf("DeleteUnchanged() = %t, %v, want the rewritten volume kept", deleted, err)
+	}
+
+	if seen, err = s.Stat("vol-1"); err != nil {
+		t.Fatal(err)
+	}
+	deleted, err = s.DeleteUnchanged("vol-1", seen)
+	if err != nil || !deleted {
+		t.Fatalf("DeleteUnchanged() = %t, %v, want the volume deleted", deleted, err)
+	}
+	if _, err := s.Stat("vol-1"); !os.IsNotExist(err) {
+		t.Errorf("state still there: %v", err)
+	}
+}
@@ -0,0 +1,201 @@
+// Copyright CERN.
+//
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cvmfs
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const talkTimeout = 10 * time.Second
+
+// talkClient speaks to the control socket of a mounted repository,
+// the same way cvmfs_talk does. Every command uses its own connection.
+type talkClient struct {
+	socket string
+}
+
+// talk returns a client for the control socket of a mounted repository.
+// The socket lives in the workspace of the client, which is the shared cache
+// directory of the repository unless its configuration sets CVMFS_WORKSPACE,
+// e.g. for preloaded repositories.
+func (d *Driver) talk(r Repository) *talkClient {
+	workspace, ok := repositoryConfigValue(r, "CVMFS_WORKSPACE")
+	if !ok {
+		workspace = filepath.Join(d.repositoryCacheBase(r), "shared")
+	}
+	return &talkClient{socket: filepath.Join(workspace, "cvmfs_io."+string(r))}
+}
+
+// Command sends a raw command and returns the answer of the client
+func (t *talkClient) Command(command string) (string, error) {
+	conn, err := net.DialTimeout("unix", t.socket, talkTimeout)
+	if err != nil {
+		return "", fmt.Errorf("cannot connect to %s: %w", t.socket, err)
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(talkTimeout)); err != nil {
+		return "", err
+	}
+	if _, err := conn.Write([]byte(command)); err != nil {
+		return "", fmt.Errorf("cannot send command '%s': %w", command, err)
+	}
+
+	// the client closes the connection after answering
+	b, err := io.ReadAll(conn)
+	if err != nil {
+		return "", fmt.Errorf("cannot read answer to '%s': %w", command, err)
+	}
+	return string(b), nil
+}
+
+// Revision returns the revision of the mounted root catalog
+func (t *talkClient) Revision() (uint64, error) {
+	s, err := t.Command("revision")
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(s), 10, 64)
+}
+
+// RootHash returns the content hash of the mounted root catalog
+func (t *talkClient) RootHash() (string, error) {
+	s, err := t.Command("root hash")
+	return strings.TrimSpace(s), err
+}
+
+// Proxy returns the proxy currently in use
+func (t *talkClient) Proxy() (string, error) {
+	s, err := t.Command("proxy info")
+	if err != nil {
+		return "", err
+	}
+	// e.g. "Active proxy: [0] http://ca-proxy.cern.ch:3128"
+	v, ok := findLineValue(s, "Active proxy")
+	if !ok {
+		return "", fmt.Errorf("no active proxy in answer: %s", s)
+	}
+	if i := strings.Index(v, "] "); strings.HasPrefix(v, "[") && i > 0 {
+		v = v[i+2:]
+	}
+	return v, nil
+}
+
+// Host returns the stratum server currently in use
+func (t *talkClient) Host() (string, error) {
+	s, err := t.Command("host info")
+	if err != nil {
+		return "", err
+	}
+	// e.g. "Active host 0: http://cvmfs-stratum-one.cern.ch/cvmfs/@fqrn@"
+	v, ok := findLineValue(s, "Active host")
+	if !ok {
+		return "", fmt.Errorf("no active host in answer: %s", s)
+	}
+	return v, nil
+}
+
+// cacheUsage is the amount of cache in use, in bytes
+type cacheUsage struct {
+	Unpinned uint64 `json:"unpinned"`
+	Pinned   uint64 `json:"pinned"`
+}
+
+var cacheSizeRegexp = regexp.MustCompile(`\((\d+) Bytes\)`)
+
+// CacheUsage returns the size of the cache the repository uses
+func (t *talkClient) CacheUsage() (cacheUsage, error) {
+	s, err := t.Command("cache size")
+	if err != nil {
+		return cacheUsage{}, err
+	}
+	// e.g. "Current cache size is 12MB (12582912 Bytes), pinned: 1MB (1048576 Bytes)"
+	m := cacheSizeRegexp.FindAllStringSubmatch(s, 2)
+	if len(m) != 2 {
+		return cacheUsage{}, fmt.Errorf("unexpected answer: %s", s)
+	}
+	unpinned, _ := strconv.ParseUint(m[0][1], 10, 64)
+	pinned, _ := strconv.ParseUint(m[1][1], 10, 64)
+	return cacheUsage{Unpinned: unpinned, Pinned: pinned}, nil
+}
+
+// OpenCatalogs lists the catalogs the client currently has loaded
+func (t *talkClient) OpenCatalogs() ([]string, error) {
+	s, err := t.Command("open catalogs")
+	if err != nil {
+		return nil, err
+	}
+	var catalogs []string
+	for _, l := range strings.Split(s, "\n") {
+		if l = strings.TrimSpace(l); l != "" {
+			catalogs = append(catalogs, l)
+		}
+	}
+	return catalogs, nil
+}
+
+// repositoryStatus is a snapshot of the state of a mounted repository
+type repositoryStatus struct {
+	Revision uint64     `json:"revision"`
+	RootHash string     `json:"rootHash"`
+	Proxy    string     `json:"proxy"`
+	Host     string     `json:"host"`
+	Cache    cacheUsage `json:"cache"`
+}
+
+// Status collects the repository status with a command per field
+func (t *talkClient) Status() (*repositoryStatus, error) {
+	var err error
+	st := &repositoryStatus{}
+	if st.Revision, err = t.Revision(); err != nil {
+		return nil, err
+	}
+	if st.RootHash, err = t.RootHash(); err != nil {
+		return nil, err
+	}
+	if st.Proxy, err = t.Proxy(); err != nil {
+		return nil, err
+	}
+	if st.Host, err = t.Host(); err != nil {
+		return nil, err
+	}
+	if st.Cache, err = t.CacheUsage(); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// findLineValue returns what follows the first colon on the first line starting with prefix
+func findLineValue(s, prefix string) (string, bool) {
+	for _, l := range strings.Split(s, "\n") {
+		l = strings.TrimSpace(l)
+		if !strings.HasPrefix(l, prefix) {
+			continue
+		}
+		if i := strings.Index(l, ": "); i >= 0 {
+			return strings.TrimSpace(l[i+2:]), true
+		}
+	}
+	return "", false
+}
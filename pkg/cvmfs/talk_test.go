@@ -0,0 +1,131 @@
+// Copyright CERN.
+//
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cvmfs
+
+import (
+	"net"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// fakeTalkSocket answers the commands of a talkClient like the control socket of a client
+func fakeTalkSocket(t *testing.T, answers map[string]string) *talkClient {
+	socket := filepath.Join(t.TempDir(), "cvmfs_io.atlas.cern.ch")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			buf := make([]byte, 1024)
+			n, _ := conn.Read(buf)
+			answer, ok := answers[string(buf[:n])]
+			if !ok {
+				answer = "unknown command\n"
+			}
+			conn.Write([]byte(answer))
+			conn.Close()
+		}
+	}()
+	return &talkClient{socket: socket}
+}
+
+func TestTalkClientStatus(t *testing.T) {
+	c := fakeTalkSocket(t, map[string]string{
+		"revision":      "4242\n",
+		"root hash":     "0123abcd\n",
+		"proxy info":    "Load-balance groups:\n[0] http://ca-proxy.cern.ch:3128 (10.0.0.1, +6h)\nActive proxy: [0] http://ca-proxy.cern.ch:3128\n",
+		"host info":     "Load-balance groups:\n[0] http://cvmfs-stratum-one.cern.ch/cvmfs/@fqrn@ (timeout, 0 ms)\nActive host 0: http://cvmfs-stratum-one.cern.ch/cvmfs/@fqrn@\n",
+		"cache size":    "Current cache size is 12MB (12582912 Bytes), pinned: 1MB (1048576 Bytes)\n",
+		"open catalogs": "Open catalogs:\n  / [hash]\n\n  /repo [hash]\n",
+	})
+
+	st, err := c.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &repositoryStatus{
+		Revision: 4242,
+		RootHash: "0123abcd",
+		Proxy:    "http://ca-proxy.cern.ch:3128",
+		Host:     "http://cvmfs-stratum-one.cern.ch/cvmfs/@fqrn@",
+		Cache:    cacheUsage{Unpinned: 12582912, Pinned: 1048576},
+	}
+	if !reflect.DeepEqual(st, want) {
+		t.Errorf("Status() = %+v, want %+v", st, want)
+	}
+
+	catalogs, err := c.OpenCatalogs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"Open catalogs:", "/ [hash]", "/repo [hash]"}; !reflect.DeepEqual(catalogs, want) {
+		t.Errorf("OpenCatalogs() = %q, want %q", catalogs, want)
+	}
+}
+
+func TestTalkClientUnexpectedAnswers(t *testing.T) {
+	c := fakeTalkSocket(t, map[string]string{
+		"revision":   "not a number\n",
+		"proxy info": "Load-balance groups:\n",
+		"host info":  "Load-balance groups:\n",
+		"cache size": "Current cache size is 12MB (12582912 Bytes)\n",
+	})
+
+	tests := map[string]func() error{
+		"revision":   func() error { _, err := c.Revision(); return err },
+		"proxy info": func() error { _, err := c.Proxy(); return err },
+		"host info":  func() error { _, err := c.Host(); return err },
+		"cache size": func() error { _, err := c.CacheUsage(); return err },
+	}
+	for command, f := range tests {
+		if err := f(); err == nil {
+			t.Errorf("%s: want an error for an unexpected answer", command)
+		}
+	}
+
+	missing := &talkClient{socket: filepath.Join(t.TempDir(), "cvmfs_io.cms.cern.ch")}
+	if _, err := missing.Command("revision"); err == nil {
+		t.Error("Command() without a socket succeeded")
+	}
+}
+
+func TestFindLineValue(t *testing.T) {
+	tests := []struct {
+		s, prefix string
+		want      string
+		wantOK    bool
+	}{
+		{s: "Active proxy: [0] http://a:3128\n", prefix: "Active proxy", want: "[0] http://a:3128", wantOK: true},
+		{s: "first\n  Active host 1: http://b/cvmfs/@fqrn@  \n", prefix: "Active host", want: "http://b/cvmfs/@fqrn@", wantOK: true},
+		{s: "Active proxy without value\nActive proxy: second\n", prefix: "Active proxy", want: "second", wantOK: true},
+		{s: "Inactive proxy: x\n", prefix: "Active proxy", wantOK: false},
+	}
+	for _, tt := range tests {
+		got, ok := findLineValue(tt.s, tt.prefix)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("findLineValue(%q, %s) = %q, %t, want %q, %t", tt.s, tt.prefix, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
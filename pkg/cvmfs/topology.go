@@ -0,0 +1,151 @@
+// Copyright CERN.
+//
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cvmfs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/cernops/cvmfs-csi/internal"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+)
+
+// Topology segment names, prefixed with the driver name to form the keys
+const (
+	topologyAvailable  = "available"
+	topologySite       = "site"
+	topologyProxyGroup = "proxy-group"
+)
+
+// availabilityAttempts is how often the node plugin tries to set up the client
+// at startup, before it reports the node as unavailable, pausing availabilityBackoff
+// between attempts
+const (
+	availabilityAttempts = 5
+	availabilityBackoff  = 5 * time.Second
+)
+
+// errNoFuse is returned when the node has no fuse, which retrying does not fix
+var errNoFuse = errors.New("fuse is not available")
+
+func (d *Driver) topologyKey(segment string) string {
+	return d.config.DriverName + "/" + segment
+}
+
+// nodeTopology describes this node to the CO, with the availability checkAvailability
+// found at startup. Kubelet asks for it once when the plugin registers, so the topology
+// stays fixed until the plugin restarts.
+func (d *Driver) nodeTopology() *csi.Topology {
+	available := "true"
+	if d.availability() != nil {
+		available = "false"
+	}
+
+	segments := map[string]string{
+		d.topologyKey(topologyAvailable): available,
+	}
+	if d.config.Site != "" {
+		segments[d.topologyKey(topologySite)] = d.config.Site
+	}
+	if d.config.ProxyGroup != "" {
+		segments[d.topologyKey(topologyProxyGroup)] = d.config.ProxyGroup
+	}
+
+	return &csi.Topology{Segments: segments}
+}
+
+// checkAvailability runs before the node plugin registers, and records whether the node
+// can serve volumes. Transient failures, e.g. of the network, are retried for a while.
+func (d *Driver) checkAvailability() {
+	log := internal.GetLogger("checkAvailability")
+
+	var err error
+	for i := 0; i < availabilityAttempts; i++ {
+		if i > 0 {
+			time.Sleep(availabilityBackoff)
+		}
+		if err = d.checkAvailable(); err == nil || errors.Is(err, errNoFuse) {
+			break
+		}
+		log.Warn().Err(err).Int("attempt", i+1).Msg("node cannot serve CVMFS volumes yet")
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("node cannot serve CVMFS volumes, marking as unavailable")
+		d.lastErrors.add("checkAvailability", "", err)
+	}
+
+	d.readyMu.Lock()
+	defer d.readyMu.Unlock()
+	d.unavailable = err
+}
+
+// availability returns why the node was found unable to serve volumes, or nil if it can
+func (d *Driver) availability() error {
+	d.readyMu.Lock()
+	defer d.readyMu.Unlock()
+	return d.unavailable
+}
+
+// checkAvailable verifies that the node has fuse and can set up the client. With a mount
+// helper the plugin has no /dev/fuse, the mount holder opening it fails the setup instead.
+func (d *Driver) checkAvailable() error {
+	if mountHelper == nil {
+		if _, err := os.Stat("/dev/fuse"); err != nil {
+			return fmt.Errorf("%w: %v", errNoFuse, err)
+		}
+	}
+
+	err := d.BasicSetup()
+	if err != nil && d.config.PreloadDir != "" {
+		// air-gapped nodes can still serve preloaded volumes
+		log := internal.GetLogger("checkAvailable")
+		log.Warn().Err(err).Msg("cannot mount the config repository, only preloaded volumes can be served")
+		err = d.offlineSetup()
+	}
+	if err != nil {
+		return fmt.Errorf("basic setup failed: %w", err)
+	}
+	return nil
+}
+
+// volumeTopology returns the topologies a new volume is accessible from,
+// which are all of the requested ones that have a working CVMFS client
+func (d *Driver) volumeTopology(req *csi.TopologyRequirement) ([]*csi.Topology, error) {
+	availableKey := d.topologyKey(topologyAvailable)
+	if req == nil {
+		return []*csi.Topology{{Segments: map[string]string{availableKey: "true"}}}, nil
+	}
+
+	candidates := req.GetRequisite()
+	if len(candidates) == 0 {
+		candidates = req.GetPreferred()
+	}
+
+	var topologies []*csi.Topology
+	for _, t := range candidates {
+		if t.GetSegments()[availableKey] == "true" {
+			topologies = append(topologies, t)
+		}
+	}
+
+	if len(topologies) == 0 {
+		return nil, fmt.Errorf("none of the requested topologies has a working CVMFS client")
+	}
+	return topologies, nil
+}
@@ -0,0 +1,73 @@
+// Copyright CERN.
+//
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cvmfs
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+)
+
+func TestVolumeTopology(t *testing.T) {
+	d := &Driver{config: DriverConfig{DriverName: "cvmfs.csi.cern.ch"}}
+	topology := func(available, site string) *csi.Topology {
+		return &csi.Topology{Segments: map[string]string{"cvmfs.csi.cern.ch/available": available, "cvmfs.csi.cern.ch/site": site}}
+	}
+	nodeA, nodeB, broken := topology("true", "a"), topology("true", "b"), topology("false", "a")
+
+	tests := []struct {
+		name    string
+		req     *csi.TopologyRequirement
+		want    []map[string]string
+		wantErr bool
+	}{
+		{name: "no requirement", req: nil, want: []map[string]string{{"cvmfs.csi.cern.ch/available": "true"}}},
+		{
+			name: "requisite",
+			req:  &csi.TopologyRequirement{Requisite: []*csi.Topology{nodeA, broken, nodeB}, Preferred: []*csi.Topology{nodeB}},
+			want: []map[string]string{nodeA.Segments, nodeB.Segments},
+		},
+		{
+			name: "preferred only",
+			req:  &csi.TopologyRequirement{Preferred: []*csi.Topology{broken, nodeB}},
+			want: []map[string]string{nodeB.Segments},
+		},
+		{
+			name:    "without available segment",
+			req:     &csi.TopologyRequirement{Requisite: []*csi.Topology{{Segments: map[string]string{"kubernetes.io/hostname": "a"}}}},
+			wantErr: true,
+		},
+		{name: "only unavailable", req: &csi.TopologyRequirement{Requisite: []*csi.Topology{broken}}, wantErr: true},
+		{name: "empty", req: &csi.TopologyRequirement{}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := d.volumeTopology(tt.req)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("volumeTopology() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var segments []map[string]string
+			for _, topo := range got {
+				segments = append(segments, topo.GetSegments())
+			}
+			if !reflect.DeepEqual(segments, tt.want) {
+				t.Errorf("volumeTopology() = %v, want %v", segments, tt.want)
+			}
+		})
+	}
+}
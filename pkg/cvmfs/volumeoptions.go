@@ -0,0 +1,296 @@
+// Copyright CERN.
+//
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cvmfs
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RefreshPolicy decides how a volume follows new revisions of its repository
+type RefreshPolicy string
+
+const (
+	// RefreshTTL follows the repository head, refreshed by the client when the catalog TTL
+	// expires, and additionally by the node server every RefreshInterval if one is set
+	RefreshTTL RefreshPolicy = "ttl"
+	// RefreshManual stays on the revision it was mounted with until it is refreshed
+	// explicitly, with the refresh admin subcommand
+	RefreshManual RefreshPolicy = "manual"
+	// RefreshPinned stays on the revision selected by Tag or Hash
+	RefreshPinned RefreshPolicy = "pinned"
+)
+
+// MountMode decides whether a volume shares the mount of its repository with other volumes
+type MountMode string
+
+const (
+	// MountShared uses the mount of the repository in /cvmfs, shared by all its volumes on a node
+	MountShared MountMode = "shared"
+	// MountPrivate mounts the repository with a client of the volume's own,
+	// with its own configuration, cache and mount point
+	MountPrivate MountMode = "private"
+)
+
+var (
+	tagRegexp        = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+	hashRegexp       = regexp.MustCompile(`^[0-9a-fA-F]+(-[a-z0-9]+)?$`)
+	cacheGroupRegexp = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+)
+
+// VolumeOptions holds the parsed volume parameters of a StorageClass
+// (or the volumeAttributes of a statically provisioned PersistentVolume)
+type VolumeOptions struct {
+	// Repository to mount, empty when Automount is set
+	Repository Repository `json:"repository,omitempty"`
+	// Automount exposes all of /cvmfs, mounting repositories on access
+	Automount bool `json:"automount,omitempty"`
+	// Subdirectory within the repository to expose instead of its root
+	Subdirectory string `json:"subdirectory,omitempty"`
+	// Tag or Hash pin the repository to a specific revision
+	Tag  string `json:"tag,omitempty"`
+	Hash string `json:"hash,omitempty"`
+	// RefreshPolicy and RefreshInterval control how new revisions are picked up
+	RefreshPolicy   RefreshPolicy `json:"refreshPolicy,omitempty"`
+	RefreshInterval time.Duration `json:"refreshInterval,omitempty"`
+	// CacheGroup gives the repository a cache directory shared only within the group
+	CacheGroup string `json:"cacheGroup,omitempty"`
+	// CacheQuota is the CVMFS_QUOTA_LIMIT of a dedicated cache, in MB
+	CacheQuota uint64 `json:"cacheQuota,omitempty"`
+	// Profile selects a site profile of the driver, which decides how the repository is reached
+	Profile string `json:"profile,omitempty"`
+	// Preload is a preloaded cache directory or archive below the preload directory of
+	// the driver. The repository is mounted from it, without reaching any server.
+	Preload string `json:"preload,omitempty"`
+	// MountMode decides whether the volume gets a mount of its own
+	MountMode MountMode `json:"mountMode,omitempty"`
+	// Protected volumes get a mount of their own, made with the credentials of the pods using them
+	Protected bool `json:"protected,omitempty"`
+}
+
+// VolumeOptionsFromContext parses the volume context passed along by the CO
+func VolumeOptionsFromContext(m map[string]string) (*VolumeOptions, error) {
+	o := &VolumeOptions{
+		Repository:    Repository(m["repository"]),
+		Subdirectory:  m["subdirectory"],
+		Tag:           m["tag"],
+		Hash:          m["hash"],
+		RefreshPolicy: RefreshPolicy(m["refreshPolicy"]),
+		CacheGroup:    m["cacheGroup"],
+		Profile:       m["profile"],
+		Preload:       m["preload"],
+		MountMode:     MountMode(m["mountMode"]),
+	}
+
+	if s, ok := m["automount"]; ok {
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid automount parameter '%s': %w", s, err)
+		}
+		o.Automount = b
+	}
+
+	if s, ok := m["protected"]; ok {
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid protected parameter '%s': %w", s, err)
+		}
+		o.Protected = b
+	}
+
+	if s, ok := m["refreshInterval"]; ok {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid refreshInterval parameter '%s': %w", s, err)
+		}
+		o.RefreshInterval = d
+	}
+
+	if s, ok := m["cacheQuota"]; ok {
+		q, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cacheQuota parameter '%s': %w", s, err)
+		}
+		o.CacheQuota = q
+	}
+
+	if o.MountMode == "" {
+		o.MountMode = MountShared
+	}
+
+	// pinning a revision implies the pinned policy, following the head the ttl policy,
+	// and a preloaded cache never changes
+	if o.RefreshPolicy == "" {
+		if o.Tag != "" || o.Hash != "" {
+			o.RefreshPolicy = RefreshPinned
+		} else if o.Preload != "" {
+			o.RefreshPolicy = RefreshManual
+		} else {
+			o.RefreshPolicy = RefreshTTL
+		}
+	}
+
+	return o, o.Validate()
+}
+
+func (o *VolumeOptions) Validate() error {
+	// the subdirectory is always relative to the repository root,
+	// a leading slash is tolerated but '..' may not escape it
+	if o.Subdirectory != "" && !isContainedPath(o.Subdirectory) {
+		return fmt.Errorf("invalid subdirectory parameter '%s'", o.Subdirectory)
+	}
+
+	if err := o.validateRefresh(); err != nil {
+		return err
+	}
+
+	if o.CacheGroup != "" && !cacheGroupRegexp.MatchString(o.CacheGroup) {
+		return fmt.Errorf("invalid cacheGroup parameter '%s'", o.CacheGroup)
+	}
+
+	if o.Profile != "" && !profileNameRegexp.MatchString(o.Profile) {
+		return fmt.Errorf("invalid profile parameter '%s'", o.Profile)
+	}
+
+	if err := o.validatePreload(); err != nil {
+		return err
+	}
+
+	if err := o.validateMountMode(); err != nil {
+		return err
+	}
+
+	if err := o.validateProtected(); err != nil {
+		return err
+	}
+
+	if o.Automount {
+		if o.CacheGroup != "" || o.CacheQuota != 0 {
+			return fmt.Errorf("automount volumes use the cache of the driver, cacheGroup and cacheQuota cannot be set")
+		}
+		if o.Profile != "" {
+			return fmt.Errorf("automount volumes use the configuration of the driver, profile cannot be set")
+		}
+		if o.Repository != "" {
+			return fmt.Errorf("repository parameter cannot be combined with automount")
+		}
+		return nil
+	}
+	return o.Repository.Validate()
+}
+
+func (o *VolumeOptions) validatePreload() error {
+	if o.Preload == "" {
+		return nil
+	}
+	if !isContainedPath(o.Preload) {
+		return fmt.Errorf("invalid preload parameter '%s'", o.Preload)
+	}
+	if o.Automount {
+		return fmt.Errorf("automount volumes cannot be preloaded")
+	}
+	if o.RefreshPolicy == RefreshTTL {
+		return fmt.Errorf("preloaded volumes cannot be refreshed, use refreshPolicy manual or pinned")
+	}
+	if o.CacheGroup != "" || o.CacheQuota != 0 {
+		return fmt.Errorf("preloaded volumes use their preloaded cache, cacheGroup and cacheQuota cannot be set")
+	}
+	return nil
+}
+
+func (o *VolumeOptions) validateMountMode() error {
+	switch o.MountMode {
+	case MountShared:
+		return nil
+	case MountPrivate:
+	default:
+		return fmt.Errorf("invalid mountMode parameter '%s'", o.MountMode)
+	}
+	if o.Automount {
+		return fmt.Errorf("automount volumes cannot be private")
+	}
+	if o.Preload != "" {
+		return fmt.Errorf("preloaded volumes cannot be private")
+	}
+	if o.CacheGroup != "" {
+		return fmt.Errorf("private volumes have a cache of their own, cacheGroup cannot be set")
+	}
+	return nil
+}
+
+func (o *VolumeOptions) validateProtected() error {
+	if !o.Protected {
+		return nil
+	}
+	if o.Automount {
+		return fmt.Errorf("automount volumes cannot be protected")
+	}
+	if o.Preload != "" {
+		return fmt.Errorf("preloaded volumes cannot be protected")
+	}
+	if o.CacheGroup != "" {
+		return fmt.Errorf("protected volumes have a cache of their own, cacheGroup cannot be set")
+	}
+	return nil
+}
+
+func (o *VolumeOptions) validateRefresh() error {
+	if o.Tag != "" && o.Hash != "" {
+		return fmt.Errorf("tag and hash parameters are mutually exclusive")
+	}
+	if o.Tag != "" && !tagRegexp.MatchString(o.Tag) {
+		return fmt.Errorf("invalid tag parameter '%s'", o.Tag)
+	}
+	if o.Hash != "" && !hashRegexp.MatchString(o.Hash) {
+		return fmt.Errorf("invalid hash parameter '%s'", o.Hash)
+	}
+	if o.RefreshInterval < 0 {
+		return fmt.Errorf("refreshInterval cannot be negative")
+	}
+
+	pinned := o.Tag != "" || o.Hash != ""
+	switch o.RefreshPolicy {
+	case RefreshTTL, RefreshManual:
+		if pinned {
+			return fmt.Errorf("refreshPolicy %s cannot be combined with a tag or hash, which are always pinned", o.RefreshPolicy)
+		}
+	case RefreshPinned:
+		if !pinned {
+			return fmt.Errorf("refreshPolicy pinned requires a tag or hash parameter")
+		}
+	default:
+		return fmt.Errorf("invalid refreshPolicy parameter '%s'", o.RefreshPolicy)
+	}
+
+	if o.RefreshInterval != 0 && o.RefreshPolicy != RefreshTTL {
+		return fmt.Errorf("refreshInterval requires refreshPolicy ttl")
+	}
+	if o.Automount && (pinned || o.RefreshInterval != 0 || o.RefreshPolicy == RefreshManual) {
+		return fmt.Errorf("automount volumes cannot be pinned or refreshed")
+	}
+	return nil
+}
+
+// isContainedPath reports whether p stays within the directory it is relative to
+func isContainedPath(p string) bool {
+	clean := path.Clean(strings.TrimLeft(p, "/"))
+	return clean != "." && clean != ".." && !strings.HasPrefix(clean, "../")
+}
@@ -0,0 +1,120 @@
+// Copyright CERN.
+//
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cvmfs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestVolumeOptionsFromContext(t *testing.T) {
+	tests := []struct {
+		name    string
+		context map[string]string
+		want    VolumeOptions
+		wantErr bool
+	}{
+		{name: "repository", context: map[string]string{"repository": "atlas.cern.ch"}, want: VolumeOptions{Repository: "atlas.cern.ch"}},
+		{name: "no repository", context: map[string]string{}, wantErr: true},
+		{name: "automount", context: map[string]string{"automount": "true"}, want: VolumeOptions{Automount: true}},
+		{name: "automount with repository", context: map[string]string{"automount": "true", "repository": "atlas.cern.ch"}, wantErr: true},
+		{name: "invalid automount", context: map[string]string{"automount": "yes please"}, wantErr: true},
+		{
+			name:    "subdirectory",
+			context: map[string]string{"repository": "atlas.cern.ch", "subdirectory": "repo/sw"},
+			want:    VolumeOptions{Repository: "atlas.cern.ch", Subdirectory: "repo/sw"},
+		},
+		{
+			name:    "subdirectory with leading slash",
+			context: map[string]string{"repository": "atlas.cern.ch", "subdirectory": "/repo/sw"},
+			want:    VolumeOptions{Repository: "atlas.cern.ch", Subdirectory: "/repo/sw"},
+		},
+		{
+			name:    "subdirectory staying inside",
+			context: map[string]string{"repository": "atlas.cern.ch", "subdirectory": "repo/../sw"},
+			want:    VolumeOptions{Repository: "atlas.cern.ch", Subdirectory: "repo/../sw"},
+		},
+		{name: "root subdirectory", context: map[string]string{"repository": "atlas.cern.ch", "subdirectory": "/"}, wantErr: true},
+		{name: "escaping subdirectory", context: map[string]string{"repository": "atlas.cern.ch", "subdirectory": "../cms.cern.ch"}, wantErr: true},
+		{name: "escaping nested subdirectory", context: map[string]string{"repository": "atlas.cern.ch", "subdirectory": "repo/../../cms.cern.ch"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := VolumeOptionsFromContext(tt.context)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("VolumeOptionsFromContext() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got.Repository != tt.want.Repository || got.Automount != tt.want.Automount || got.Subdirectory != tt.want.Subdirectory {
+				t.Errorf("VolumeOptionsFromContext() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveSubdirectory(t *testing.T) {
+	// resolved, so the results compare equal where the temporary directory is a symlink
+	root, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	outside := t.TempDir()
+	for _, d := range []string{"repo/sw", "repo/data"} {
+		if err := os.MkdirAll(filepath.Join(root, d), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(root, "repo/README"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	links := map[string]string{"latest": "repo/sw", "escape": outside, "dangling": "repo/gone"}
+	for name, to := range links {
+		if err := os.Symlink(to, filepath.Join(root, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name         string
+		subdirectory string
+		want         string
+		wantErr      bool
+	}{
+		{name: "directory", subdirectory: "repo/sw", want: filepath.Join(root, "repo/sw")},
+		{name: "leading slash", subdirectory: "/repo/data", want: filepath.Join(root, "repo/data")},
+		{name: "symlink inside", subdirectory: "latest", want: filepath.Join(root, "repo/sw")},
+		{name: "symlink outside", subdirectory: "escape", wantErr: true},
+		{name: "dangling symlink", subdirectory: "dangling", wantErr: true},
+		{name: "missing", subdirectory: "repo/gone", wantErr: true},
+		{name: "file", subdirectory: "repo/README", wantErr: true},
+		{name: "escaping", subdirectory: "../etc", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveSubdirectory(root, tt.subdirectory)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolveSubdirectory() = %s, error = %v, wantErr %v", got, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("resolveSubdirectory() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}